@@ -0,0 +1,157 @@
+package buffchan
+
+import (
+	"context"
+	"sync"
+)
+
+// BroadcastChannel is an unlimited channel whose elements are received by
+// every consumer. Each consumer has its own cursor over the stream of elements,
+// elements are released once every consumer has removed them.
+type BroadcastChannel[T any] struct {
+	m *sync.Mutex
+	c *sync.Cond // broadcasted when an element is appended or a channel or consumer is closed
+
+	// tail is the node that holds the next appended element, the consumers
+	// reference the nodes they have not removed yet
+	tail   *node[T]
+	closed bool
+}
+
+type node[T any] struct {
+	v    T
+	seq  uint64 // position in the stream
+	next *node[T]
+}
+
+// Consumer receives the elements appended to a broadcast channel after its subscription
+type Consumer[T any] struct {
+	b      *BroadcastChannel[T]
+	cursor *node[T] // next element to remove, it is the tail while there is none
+	closed bool
+}
+
+// NewBroadcast creates new broadcast channel
+func NewBroadcast[T any]() *BroadcastChannel[T] {
+	m := new(sync.Mutex)
+	return &BroadcastChannel[T]{
+		m:    m,
+		c:    sync.NewCond(m),
+		tail: new(node[T]),
+	}
+}
+
+// Subscribe registers a new consumer that receives the elements appended from now on
+func (b *BroadcastChannel[T]) Subscribe() *Consumer[T] {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return &Consumer[T]{b: b, cursor: b.tail, closed: b.closed}
+}
+
+// Append adds given data at end of the stream of every consumer, it fails once
+// the channel is closed
+func (b *BroadcastChannel[T]) Append(v T) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	b.tail.v = v
+	b.tail.next = &node[T]{seq: b.tail.seq + 1}
+	b.tail = b.tail.next
+	b.c.Broadcast()
+
+	return nil
+}
+
+// Close closes the channel and wakes up every blocked Remove of its consumers.
+// Closing a closed channel has no effect.
+func (b *BroadcastChannel[T]) Close() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.closed = true
+	b.c.Broadcast()
+}
+
+// Remove removes the next element of the consumer synchronously. The elements
+// of a closed channel are still removed, then Remove fails.
+func (c *Consumer[T]) Remove() (T, error) {
+	return c.RemoveContext(context.Background())
+}
+
+// RemoveContext is like Remove but fails with the error of the context when it
+// is done before an element is available
+func (c *Consumer[T]) RemoveContext(ctx context.Context) (T, error) {
+	b := c.b
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	ready := func() bool { return !c.closed && c.cursor != b.tail }
+	err := wait(ctx, b.c, ready, func() bool { return c.closed || b.closed })
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return c.remove(), nil
+}
+
+// Inspect the next element of the consumer if exists
+func (c *Consumer[T]) Inspect() (T, bool) {
+	c.b.m.Lock()
+	defer c.b.m.Unlock()
+
+	if c.closed || c.cursor == c.b.tail {
+		var zero T
+		return zero, false
+	}
+
+	return c.cursor.v, true
+}
+
+// AsyncRemove removes the next element of the consumer asynchronously
+func (c *Consumer[T]) AsyncRemove() (T, bool) {
+	c.b.m.Lock()
+	defer c.b.m.Unlock()
+
+	if c.closed || c.cursor == c.b.tail {
+		var zero T
+		return zero, false
+	}
+
+	return c.remove(), true
+}
+
+// Len returns the number of elements the consumer has not removed yet
+func (c *Consumer[T]) Len() int {
+	c.b.m.Lock()
+	defer c.b.m.Unlock()
+
+	if c.closed {
+		return 0
+	}
+	return int(c.b.tail.seq - c.cursor.seq)
+}
+
+// Close unsubscribes the consumer, which releases the elements it has not
+// removed and wakes up its blocked Remove. Closing a closed consumer has no effect.
+func (c *Consumer[T]) Close() {
+	c.b.m.Lock()
+	defer c.b.m.Unlock()
+
+	c.closed = true
+	c.cursor = nil
+	c.b.c.Broadcast()
+}
+
+// remove must be called with the lock held on a consumer with an element
+func (c *Consumer[T]) remove() T {
+	v := c.cursor.v
+	c.cursor = c.cursor.next
+
+	return v
+}
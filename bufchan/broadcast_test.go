@@ -0,0 +1,123 @@
+package buffchan
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBroadcastCursors(t *testing.T) {
+	b := NewBroadcast[int]()
+	b.Append(0) // appended before any subscription
+
+	c1 := b.Subscribe()
+	b.Append(1)
+	b.Append(2)
+	c2 := b.Subscribe()
+	b.Append(3)
+
+	if c1.Len() != 3 || c2.Len() != 1 {
+		t.Fatalf("Len returned %d and %d, expected 3 and 1", c1.Len(), c2.Len())
+	}
+	if v, ok := c1.Inspect(); !ok || v != 1 {
+		t.Fatalf("Inspect returned %v, %v, expected 1", v, ok)
+	}
+	for i := 1; i <= 3; i++ {
+		if v, err := c1.Remove(); err != nil || v != i {
+			t.Fatalf("Remove returned %v, %v, expected %d", v, err, i)
+		}
+	}
+	if v, ok := c1.AsyncRemove(); ok {
+		t.Fatalf("AsyncRemove returned %v on a consumer without elements", v)
+	}
+	// the elements removed by a consumer are still received by the others
+	if v, ok := c2.AsyncRemove(); !ok || v != 3 {
+		t.Fatalf("AsyncRemove returned %v, %v, expected 3", v, ok)
+	}
+
+	c2.Close()
+	b.Append(4)
+	if _, err := c2.Remove(); err != ErrClosed {
+		t.Fatalf("Remove returned %v on a closed consumer, expected ErrClosed", err)
+	}
+	if c2.Len() != 0 || c1.Len() != 1 {
+		t.Fatalf("Len returned %d and %d, expected 1 and 0", c1.Len(), c2.Len())
+	}
+}
+
+func TestBroadcastClose(t *testing.T) {
+	b := NewBroadcast[int]()
+	c := b.Subscribe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.RemoveContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("RemoveContext returned %v, expected DeadlineExceeded", err)
+	}
+
+	removed := make(chan error)
+	go func() {
+		_, err := b.Subscribe().Remove()
+		removed <- err
+	}()
+	b.Append(1)
+	time.Sleep(50 * time.Millisecond)
+	b.Close()
+	select {
+	case err := <-removed:
+		if err != ErrClosed {
+			t.Fatalf("blocked Remove returned %v after Close, expected ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Remove is still blocked after Close")
+	}
+
+	if err := b.Append(2); err != ErrClosed {
+		t.Fatalf("Append returned %v on a closed channel, expected ErrClosed", err)
+	}
+	// the elements of a closed channel are still removed
+	if v, err := c.Remove(); err != nil || v != 1 {
+		t.Fatalf("Remove returned %v, %v, expected 1", v, err)
+	}
+	if _, err := c.Remove(); err != ErrClosed {
+		t.Fatalf("Remove returned %v on a closed channel, expected ErrClosed", err)
+	}
+}
+
+// TestBroadcastConcurrent is meant to be run with the race detector
+func TestBroadcastConcurrent(t *testing.T) {
+	const (
+		consumers = 4
+		rounds    = 1000
+	)
+	b := NewBroadcast[int]()
+
+	var wg sync.WaitGroup
+	for i := 0; i < consumers; i++ {
+		c := b.Subscribe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				v, err := c.Remove()
+				if err != nil {
+					if i != rounds {
+						t.Errorf("consumer removed %d elements, expected %d", i, rounds)
+					}
+					return
+				}
+				if v != i {
+					t.Errorf("consumer removed %d, expected %d", v, i)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < rounds; i++ {
+		b.Append(i)
+	}
+	b.Close()
+	wg.Wait()
+}
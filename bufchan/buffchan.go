@@ -2,74 +2,267 @@ package buffchan
 
 import (
 	"container/list"
+	"context"
+	"errors"
 	"sync"
 )
 
-// BufferedChannel provides go channel like interface with unlimited storage
-type BufferedChannel struct {
+var (
+	// ErrClosed is returned by the operations of a closed channel
+	ErrClosed = errors.New("[buffchan] channel is closed")
+	// ErrFull is returned by TryAppend when a bounded channel is full
+	ErrFull = errors.New("[buffchan] channel is full")
+)
+
+// BufferedChannel provides go channel like interface with unlimited storage,
+// or with a fixed capacity when it is created by NewBounded. Its elements are
+// sent and received either by its methods or by the channels of In and Out.
+type BufferedChannel[T any] struct {
 	m *sync.Mutex
 	l *list.List
-	c *sync.Cond
+	c *sync.Cond // signaled when an element is appended or the channel is closed
+	f *sync.Cond // signaled when an element is removed or the channel is closed
+
+	capacity int // zero for unlimited storage
+	closed   bool
+
+	inOnce, outOnce, doneOnce sync.Once
+	in                        chan T
+	out                       chan T
+	done                      chan struct{} // closed by Close to release In and Out
 }
 
 // New Creates new buffer channel
-func New() *BufferedChannel {
+func New[T any]() *BufferedChannel[T] {
+	return NewBounded[T](0)
+}
+
+// NewBounded creates new buffer channel that holds at most capacity elements,
+// a capacity that is not positive gives unlimited storage
+func NewBounded[T any](capacity int) *BufferedChannel[T] {
+	if capacity < 0 {
+		capacity = 0
+	}
 	m := new(sync.Mutex)
-	return &BufferedChannel{
-		m: m,
-		l: list.New(),
-		c: sync.NewCond(m),
+	return &BufferedChannel[T]{
+		m:        m,
+		l:        list.New(),
+		c:        sync.NewCond(m),
+		f:        sync.NewCond(m),
+		capacity: capacity,
+		done:     make(chan struct{}),
 	}
 }
 
-// Append adds given data at end of channel
-func (b *BufferedChannel) Append(v interface{}) {
+// Append adds given data at end of channel, it blocks while a bounded channel
+// is full and fails once the channel is closed
+func (b *BufferedChannel[T]) Append(v T) error {
 	b.m.Lock()
 	defer b.m.Unlock()
 
+	for !b.closed && b.full() {
+		b.f.Wait()
+	}
+	if b.closed {
+		return ErrClosed
+	}
+
 	b.l.PushBack(v)
 	b.c.Signal()
 
+	return nil
 }
 
-// Remove removes first element of list synchronously
-func (b *BufferedChannel) Remove() interface{} {
+// TryAppend is like Append but fails instead of blocking when the channel is full
+func (b *BufferedChannel[T]) TryAppend(v T) error {
 	b.m.Lock()
 	defer b.m.Unlock()
 
-	for b.l.Len() == 0 {
-		b.c.Wait()
+	if b.closed {
+		return ErrClosed
+	}
+	if b.full() {
+		return ErrFull
 	}
 
-	v := b.l.Front()
-	b.l.Remove(v)
+	b.l.PushBack(v)
+	b.c.Signal()
+
+	return nil
+}
+
+// Remove removes first element of list synchronously. The elements of a closed
+// channel are still removed, then Remove fails.
+func (b *BufferedChannel[T]) Remove() (T, error) {
+	return b.RemoveContext(context.Background())
+}
+
+// RemoveContext is like Remove but fails with the error of the context when it
+// is done before an element is available
+func (b *BufferedChannel[T]) RemoveContext(ctx context.Context) (T, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	err := wait(ctx, b.c, func() bool { return b.l.Len() > 0 }, func() bool { return b.closed })
+	if err != nil {
+		var zero T
+		return zero, err
+	}
 
-	return v.Value
+	return b.remove(), nil
 }
 
 // Inspect first element of list if exists
-func (b *BufferedChannel) Inspect() interface{} {
+func (b *BufferedChannel[T]) Inspect() (T, bool) {
 	b.m.Lock()
 	defer b.m.Unlock()
 
 	for b.l.Len() == 0 {
-		return nil
+		var zero T
+		return zero, false
 	}
 
-	return b.l.Front().Value
+	return b.l.Front().Value.(T), true
 }
 
 // AsyncRemove removes first element of list asynchronously
-func (b *BufferedChannel) AsyncRemove() interface{} {
+func (b *BufferedChannel[T]) AsyncRemove() (T, bool) {
 	b.m.Lock()
 	defer b.m.Unlock()
 
 	for b.l.Len() == 0 {
-		return nil
+		var zero T
+		return zero, false
+	}
+
+	return b.remove(), true
+}
+
+// In returns a channel whose values are appended to the channel, so appends
+// may be used in a select. Closing it closes the channel once its values are
+// appended, but unlike Close lets Out receive the remaining elements. Sends
+// block while a bounded channel is full. Once the channel is closed, sends
+// never block and their values are discarded until In is closed.
+func (b *BufferedChannel[T]) In() chan<- T {
+	b.inOnce.Do(func() {
+		b.in = make(chan T)
+		go func() {
+			for v := range b.in {
+				if b.Append(v) != nil {
+					break
+				}
+			}
+			b.close()
+			for range b.in {
+			}
+		}()
+	})
+	return b.in
+}
+
+// Out returns a channel that receives the removed elements in order, so removes
+// may be used in a select. It is closed when the channel is closed and empty.
+// One element may be held by Out until it is received, so Out should not be
+// mixed with the other ways of removing elements. Close closes Out at once and
+// leaves the element it holds in the channel.
+func (b *BufferedChannel[T]) Out() <-chan T {
+	b.outOnce.Do(func() {
+		b.out = make(chan T)
+		go func() {
+			defer close(b.out)
+			for {
+				v, err := b.Remove()
+				if err != nil {
+					return
+				}
+				select {
+				case <-b.done:
+					b.unremove(v)
+					return
+				default:
+				}
+				select {
+				case b.out <- v:
+				case <-b.done:
+					b.unremove(v)
+					return
+				}
+			}
+		}()
+	})
+	return b.out
+}
+
+// Len returns the number of elements in the channel
+func (b *BufferedChannel[T]) Len() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.l.Len()
+}
+
+// Close closes the channel and wakes up every blocked Append and Remove. The
+// remaining elements are still removed by Remove, but no longer sent to Out.
+// Closing a closed channel has no effect.
+func (b *BufferedChannel[T]) Close() {
+	b.close()
+	b.doneOnce.Do(func() { close(b.done) })
+}
+
+// close closes the channel but lets Out receive its remaining elements
+func (b *BufferedChannel[T]) close() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.closed = true
+	b.c.Broadcast()
+	b.f.Broadcast()
+}
+
+// wait blocks on c until ready reports true, or fails with ErrClosed once
+// closed reports true or with the error of the context once it is done. It
+// must be called with the lock of c held.
+func wait(ctx context.Context, c *sync.Cond, ready, closed func() bool) error {
+	// the waiters check the context whenever they are woken up
+	stop := context.AfterFunc(ctx, func() {
+		c.L.Lock()
+		defer c.L.Unlock()
+
+		c.Broadcast()
+	})
+	defer stop()
+
+	for !ready() {
+		if closed() {
+			return ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.Wait()
 	}
+	return nil
+}
+
+// full must be called with the lock held
+func (b *BufferedChannel[T]) full() bool {
+	return b.capacity > 0 && b.l.Len() >= b.capacity
+}
+
+// unremove puts back an element removed by Out at the front of the channel
+func (b *BufferedChannel[T]) unremove(v T) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.l.PushFront(v)
+	b.c.Signal()
+}
 
+// remove must be called with the lock held on a non empty channel
+func (b *BufferedChannel[T]) remove() T {
 	v := b.l.Front()
 	b.l.Remove(v)
+	b.f.Signal()
 
-	return v.Value
+	return v.Value.(T)
 }
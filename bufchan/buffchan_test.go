@@ -0,0 +1,314 @@
+package buffchan
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrder(t *testing.T) {
+	b := New[int]()
+	if v, ok := b.AsyncRemove(); ok {
+		t.Fatalf("AsyncRemove returned %v on an empty channel", v)
+	}
+	for i := 0; i < 100; i++ {
+		if err := b.Append(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, ok := b.Inspect(); !ok || v != 0 {
+		t.Fatalf("Inspect returned %v, expected 0", v)
+	}
+	for i := 0; i < 100; i++ {
+		v, err := b.Remove()
+		if err != nil || v != i {
+			t.Fatalf("Remove returned %v, %v, expected %d", v, err, i)
+		}
+	}
+	if b.Len() != 0 {
+		t.Fatalf("Len returned %d, expected 0", b.Len())
+	}
+}
+
+func TestBounded(t *testing.T) {
+	b := NewBounded[int](2)
+	b.Append(1)
+	if err := b.TryAppend(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TryAppend(3); err != ErrFull {
+		t.Fatalf("TryAppend returned %v on a full channel, expected ErrFull", err)
+	}
+
+	appended := make(chan error)
+	go func() {
+		appended <- b.Append(3)
+	}()
+	select {
+	case err := <-appended:
+		t.Fatalf("Append returned %v on a full channel instead of blocking", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if v, ok := b.AsyncRemove(); !ok || v != 1 {
+		t.Fatalf("AsyncRemove returned %v, expected 1", v)
+	}
+	select {
+	case err := <-appended:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Append is still blocked after a remove")
+	}
+	if b.Len() != 2 {
+		t.Fatalf("Len returned %d, expected 2", b.Len())
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := NewBounded[int](1)
+	b.Append(1)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := b.Append(2); err != ErrClosed {
+			t.Errorf("blocked Append returned %v after Close, expected ErrClosed", err)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.Close()
+	wg.Wait()
+
+	// the elements of a closed channel are still removed
+	if v, err := b.Remove(); err != nil || v != 1 {
+		t.Fatalf("Remove returned %v, %v, expected 1", v, err)
+	}
+	if _, err := b.Remove(); err != ErrClosed {
+		t.Fatalf("Remove returned %v on a closed channel, expected ErrClosed", err)
+	}
+	if err := b.TryAppend(3); err != ErrClosed {
+		t.Fatalf("TryAppend returned %v on a closed channel, expected ErrClosed", err)
+	}
+	b.Close()
+
+	// Close wakes up blocked removes
+	b = New[int]()
+	removed := make(chan error)
+	go func() {
+		_, err := b.Remove()
+		removed <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.Close()
+	select {
+	case err := <-removed:
+		if err != ErrClosed {
+			t.Fatalf("blocked Remove returned %v after Close, expected ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Remove is still blocked after Close")
+	}
+}
+
+func TestRemoveContext(t *testing.T) {
+	b := New[int]()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := b.RemoveContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("RemoveContext returned %v, expected DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > time.Second {
+		t.Fatalf("RemoveContext returned after %s, expected 50ms", d)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		b.Append(1)
+	}()
+	if v, err := b.RemoveContext(ctx); err != nil || v != 1 {
+		t.Fatalf("RemoveContext returned %v, %v, expected 1", v, err)
+	}
+	cancel()
+	b.Append(2)
+	if _, err := b.RemoveContext(ctx); err != nil {
+		// an available element is removed even if the context is done
+		t.Fatalf("RemoveContext returned %v with an available element", err)
+	}
+}
+
+// TestConcurrent is meant to be run with the race detector
+func TestConcurrent(t *testing.T) {
+	const (
+		producers = 4
+		consumers = 4
+		rounds    = 1000
+	)
+	b := NewBounded[int](8)
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if err := b.Append(i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	counts := make(chan int)
+	for c := 0; c < consumers; c++ {
+		go func() {
+			n := 0
+			for {
+				if _, err := b.Remove(); err != nil {
+					counts <- n
+					return
+				}
+				n++
+			}
+		}()
+	}
+
+	wg.Wait()
+	for b.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	b.Close()
+
+	total := 0
+	for c := 0; c < consumers; c++ {
+		total += <-counts
+	}
+	if total != producers*rounds {
+		t.Fatalf("consumers removed %d elements, expected %d", total, producers*rounds)
+	}
+}
+
+func TestInOut(t *testing.T) {
+	b := New[int]()
+	in, out := b.In(), b.Out()
+
+	// sends never block an unbounded channel, even without a receiver
+	for i := 0; i < 100; i++ {
+		select {
+		case in <- i:
+		case <-time.After(time.Second):
+			t.Fatal("send to In blocked on an unbounded channel")
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		select {
+		case v := <-out:
+			if v != i {
+				t.Fatalf("Out received %d, expected %d", v, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Out received nothing, expected %d", i)
+		}
+	}
+	select {
+	case v := <-out:
+		t.Fatalf("Out received %d from an empty channel", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// closing In closes Out once the sent values are received
+	in <- 100
+	close(in)
+	if v, ok := <-out; !ok || v != 100 {
+		t.Fatalf("Out received %d, %v, expected 100, true", v, ok)
+	}
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Fatal("Out is not closed after In is closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Out is still open after In is closed")
+	}
+	if err := b.Append(1); err != ErrClosed {
+		t.Fatalf("Append returned %v after In is closed, expected ErrClosed", err)
+	}
+}
+
+func TestInBounded(t *testing.T) {
+	b := NewBounded[int](1)
+	in := b.In()
+	in <- 1
+	in <- 2 // held by In until there is room
+
+	select {
+	case in <- 3:
+		t.Fatal("send to In did not block on a full channel")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	out := b.Out()
+	for i := 1; i <= 3; i++ {
+		if i == 3 {
+			in <- 3
+		}
+		if v := <-out; v != i {
+			t.Fatalf("Out received %d, expected %d", v, i)
+		}
+	}
+	b.Close()
+	if _, ok := <-out; ok {
+		t.Fatal("Out is not closed after Close")
+	}
+}
+
+func TestInOutClose(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	b := New[int]()
+	in, out := b.In(), b.Out()
+	in <- 1
+	in <- 2
+	time.Sleep(50 * time.Millisecond) // Out holds 1 until it is received
+
+	// Close closes Out without a receiver and leaves its element in the channel
+	b.Close()
+	time.Sleep(50 * time.Millisecond)
+	if v, ok := <-out; ok {
+		t.Fatalf("Out received %d after Close", v)
+	}
+	for i := 1; i <= 2; i++ {
+		if v, err := b.Remove(); err != nil || v != i {
+			t.Fatalf("Remove returned %d, %v after Close, expected %d, nil", v, err, i)
+		}
+	}
+
+	// values sent after Close are discarded
+	for i := 3; i < 10; i++ {
+		select {
+		case in <- i:
+		case <-time.After(time.Second):
+			t.Fatal("send to In blocked after Close")
+		}
+	}
+	if _, err := b.Remove(); err != ErrClosed {
+		t.Fatalf("Remove returned %v after Close, expected ErrClosed", err)
+	}
+
+	// closing In releases the last goroutine
+	close(in)
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left after Close, expected %d", runtime.NumGoroutine(), goroutines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
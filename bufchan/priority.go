@@ -0,0 +1,140 @@
+package buffchan
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// PriorityChannel is an unlimited channel whose elements are removed by
+// decreasing priority, elements of the same priority are removed in order
+type PriorityChannel[T any] struct {
+	m *sync.Mutex
+	h *priorityHeap[T]
+	c *sync.Cond // signaled when an element is appended or the channel is closed
+
+	seq    uint64 // order of the next appended element
+	closed bool
+}
+
+type prioritized[T any] struct {
+	v        T
+	priority int
+	seq      uint64
+}
+
+// priorityHeap implements heap.Interface with the highest priority first
+type priorityHeap[T any] []prioritized[T]
+
+func (h priorityHeap[T]) Len() int { return len(h) }
+
+func (h priorityHeap[T]) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h priorityHeap[T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *priorityHeap[T]) Push(x any) { *h = append(*h, x.(prioritized[T])) }
+
+func (h *priorityHeap[T]) Pop() any {
+	old := *h
+	n := len(old) - 1
+	x := old[n]
+	old[n] = prioritized[T]{} // release the value
+	*h = old[:n]
+	return x
+}
+
+// NewPriority creates new priority channel
+func NewPriority[T any]() *PriorityChannel[T] {
+	m := new(sync.Mutex)
+	return &PriorityChannel[T]{
+		m: m,
+		h: new(priorityHeap[T]),
+		c: sync.NewCond(m),
+	}
+}
+
+// Append adds given data with given priority, it fails once the channel is closed
+func (b *PriorityChannel[T]) Append(v T, priority int) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	heap.Push(b.h, prioritized[T]{v: v, priority: priority, seq: b.seq})
+	b.seq++
+	b.c.Signal()
+
+	return nil
+}
+
+// Remove removes the element with the highest priority synchronously. The
+// elements of a closed channel are still removed, then Remove fails.
+func (b *PriorityChannel[T]) Remove() (T, error) {
+	return b.RemoveContext(context.Background())
+}
+
+// RemoveContext is like Remove but fails with the error of the context when it
+// is done before an element is available
+func (b *PriorityChannel[T]) RemoveContext(ctx context.Context) (T, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	err := wait(ctx, b.c, func() bool { return b.h.Len() > 0 }, func() bool { return b.closed })
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return heap.Pop(b.h).(prioritized[T]).v, nil
+}
+
+// Inspect the element with the highest priority if exists
+func (b *PriorityChannel[T]) Inspect() (T, bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.h.Len() == 0 {
+		var zero T
+		return zero, false
+	}
+
+	return (*b.h)[0].v, true
+}
+
+// AsyncRemove removes the element with the highest priority asynchronously
+func (b *PriorityChannel[T]) AsyncRemove() (T, bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.h.Len() == 0 {
+		var zero T
+		return zero, false
+	}
+
+	return heap.Pop(b.h).(prioritized[T]).v, true
+}
+
+// Len returns the number of elements in the channel
+func (b *PriorityChannel[T]) Len() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.h.Len()
+}
+
+// Close closes the channel and wakes up every blocked Remove.
+// Closing a closed channel has no effect.
+func (b *PriorityChannel[T]) Close() {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.closed = true
+	b.c.Broadcast()
+}
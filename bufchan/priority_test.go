@@ -0,0 +1,74 @@
+package buffchan
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPriorityOrder(t *testing.T) {
+	b := NewPriority[string]()
+	if v, ok := b.AsyncRemove(); ok {
+		t.Fatalf("AsyncRemove returned %v on an empty channel", v)
+	}
+	b.Append("low1", 0)
+	b.Append("high1", 2)
+	b.Append("mid", 1)
+	b.Append("low2", 0)
+	b.Append("high2", 2)
+	b.Append("negative", -1)
+
+	if v, ok := b.Inspect(); !ok || v != "high1" {
+		t.Fatalf("Inspect returned %v, %v, expected high1", v, ok)
+	}
+	// equal priorities are removed in order
+	expected := []string{"high1", "high2", "mid", "low1", "low2", "negative"}
+	for _, e := range expected {
+		v, err := b.Remove()
+		if err != nil || v != e {
+			t.Fatalf("Remove returned %v, %v, expected %s", v, err, e)
+		}
+	}
+	if b.Len() != 0 {
+		t.Fatalf("Len returned %d, expected 0", b.Len())
+	}
+}
+
+func TestPriorityClose(t *testing.T) {
+	b := NewPriority[int]()
+
+	removed := make(chan int)
+	go func() {
+		v, _ := b.Remove()
+		removed <- v
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.Append(1, 0)
+	select {
+	case v := <-removed:
+		if v != 1 {
+			t.Fatalf("blocked Remove returned %d, expected 1", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Remove is still blocked after an append")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := b.RemoveContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("RemoveContext returned %v, expected DeadlineExceeded", err)
+	}
+
+	b.Append(2, 0)
+	b.Close()
+	if err := b.Append(3, 0); err != ErrClosed {
+		t.Fatalf("Append returned %v on a closed channel, expected ErrClosed", err)
+	}
+	// the elements of a closed channel are still removed
+	if v, err := b.Remove(); err != nil || v != 2 {
+		t.Fatalf("Remove returned %v, %v, expected 2", v, err)
+	}
+	if _, err := b.Remove(); err != ErrClosed {
+		t.Fatalf("Remove returned %v on a closed channel, expected ErrClosed", err)
+	}
+}
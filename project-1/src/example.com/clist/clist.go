@@ -1,65 +1,234 @@
 package clist
 
 import (
-	"container/list"
+	"iter"
+	"sync"
 )
 
-// ConcurrentList represents a synchronized doubly linked list. The zero value for List is an empty list ready to use.
-type ConcurrentList struct {
-	mutex chan int
-	items *list.List
+// ConcurrentList represents a synchronized doubly linked list of values of type T.
+// The zero value for ConcurrentList is an empty list ready to use.
+type ConcurrentList[T comparable] struct {
+	mu    sync.RWMutex
+	root  Element[T] // sentinel, root.next is the front and root.prev the back
+	len   int
+	index index[T] // nil unless created by NewIndexed
+}
+
+// Element is the handle of a value pushed into a list.
+type Element[T comparable] struct {
+	// Value is the value stored with this element, it must not be changed.
+	Value T
+
+	next, prev *Element[T]
+	list       *ConcurrentList[T] // nil once removed
+}
+
+// index is kept up to date with the elements of a list under its write lock
+type index[T comparable] interface {
+	add(e *Element[T])
+	remove(e *Element[T])
+}
+
+// keyIndex maps the key of each value to its element
+type keyIndex[T, K comparable] struct {
+	key      func(T) K
+	elements map[K]*Element[T]
+}
+
+func (x *keyIndex[T, K]) add(e *Element[T]) {
+	x.elements[x.key(e.Value)] = e
+}
+
+func (x *keyIndex[T, K]) remove(e *Element[T]) {
+	k := x.key(e.Value)
+	if x.elements[k] == e {
+		delete(x.elements, k)
+	}
 }
 
 // New returns an initialized list.
-func New() *ConcurrentList {
-	return &ConcurrentList{
-		mutex: make(chan int, 1),
-		items: list.New(),
+func New[T comparable]() *ConcurrentList[T] {
+	return new(ConcurrentList[T])
+}
+
+// NewIndexed returns an initialized list that indexes its values by the key
+// returned by fn, so they can be looked up with Lookup in O(1). Keys should be
+// unique, a value replaces the value with the same key in the index.
+func NewIndexed[T, K comparable](fn func(v T) K) *ConcurrentList[T] {
+	return &ConcurrentList[T]{
+		index: &keyIndex[T, K]{
+			key:      fn,
+			elements: make(map[K]*Element[T]),
+		},
+	}
+}
+
+// Lookup returns the element of the value with the given key in a list created
+// by NewIndexed with keys of type K and reports whether there is such a value.
+func Lookup[T, K comparable](cl *ConcurrentList[T], key K) (*Element[T], bool) {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	x, ok := cl.index.(*keyIndex[T, K])
+	if !ok {
+		return nil, false
+	}
+	e, ok := x.elements[key]
+	return e, ok
+}
+
+// lazyInit links the sentinel of a zero list, it must be called with the write lock held
+func (cl *ConcurrentList[T]) lazyInit() {
+	if cl.root.next == nil {
+		cl.root.next = &cl.root
+		cl.root.prev = &cl.root
+	}
+}
+
+// PushBack inserts the value v at the back of the list and returns its element,
+// which removes the value in O(1) with RemoveElement.
+func (cl *ConcurrentList[T]) PushBack(v T) *Element[T] {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.lazyInit()
+	e := &Element[T]{Value: v, prev: cl.root.prev, next: &cl.root, list: cl}
+	e.prev.next = e
+	e.next.prev = e
+	cl.len++
+	if cl.index != nil {
+		cl.index.add(e)
 	}
+	return e
 }
 
-// PushBack inserts a new element e with value v at the back of list l and returns e.
-func (cl *ConcurrentList) PushBack(v interface{}) *list.Element {
-	cl.mutex <- 1
-	defer func() { <-cl.mutex }()
+// Len returns the number of values of the list. The complexity is O(1).
+func (cl *ConcurrentList[T]) Len() int {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
 
-	return cl.items.PushBack(v)
+	return cl.len
 }
 
-// Len returns the number of elements of list l. The complexity is O(1).
-func (cl *ConcurrentList) Len() int {
-	cl.mutex <- 1
-	defer func() { <-cl.mutex }()
+// RemoveElement removes the value of the element from the list in O(1) and
+// reports whether it was still in the list.
+func (cl *ConcurrentList[T]) RemoveElement(e *Element[T]) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
 
-	return cl.items.Len()
+	if e.list != cl {
+		return false
+	}
+	cl.remove(e)
+	return true
 }
 
-// Remove removes first occurrence of v from l if v is an element of list l. It returns the element value v.
-func (cl *ConcurrentList) Remove(v interface{}) interface{} {
-	cl.mutex <- 1
-	defer func() { <-cl.mutex }()
+// Remove removes the first occurrence of v from the list and reports whether v was found.
+// It walks the list, RemoveElement removes a value by its element in O(1).
+func (cl *ConcurrentList[T]) Remove(v T) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
 
-	for e := cl.items.Front(); e != nil; e = e.Next() {
+	for e := cl.front(); e != nil; e = cl.next(e) {
 		if e.Value == v {
-			return cl.items.Remove(e)
+			cl.remove(e)
+			return true
 		}
 	}
+	return false
+}
 
-	return nil
+// RemoveFunc removes every value for which fn returns true and returns the
+// number of removed values. fn must not call the methods of the list.
+func (cl *ConcurrentList[T]) RemoveFunc(fn func(v T) bool) int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	n := 0
+	for e := cl.front(); e != nil; {
+		next := cl.next(e)
+		if fn(e.Value) {
+			cl.remove(e)
+			n++
+		}
+		e = next
+	}
+	return n
 }
 
-// Iter iterates over the items in the concurrent list
-func (cl *ConcurrentList) Iter() <-chan *list.Element {
-	c := make(chan *list.Element)
+// Find returns the first value for which fn returns true and reports whether
+// there is such a value. fn must not change the list.
+func (cl *ConcurrentList[T]) Find(fn func(v T) bool) (T, bool) {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
 
-	go func() {
-		cl.mutex <- 1
-		defer func() { <-cl.mutex }()
-		for e := cl.items.Front(); e != nil; e = e.Next() {
-			c <- e
+	for e := cl.front(); e != nil; e = cl.next(e) {
+		if fn(e.Value) {
+			return e.Value, true
 		}
-		close(c)
-	}()
+	}
+	var zero T
+	return zero, false
+}
 
-	return c
+// Range calls fn for the values of the list from front to back until fn
+// returns false. The list is read locked during Range, so fn must not change it.
+func (cl *ConcurrentList[T]) Range(fn func(v T) bool) {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	for e := cl.front(); e != nil; e = cl.next(e) {
+		if !fn(e.Value) {
+			return
+		}
+	}
+}
+
+// Iter iterates over a snapshot of the values of the list. The list is not
+// locked during the iteration, which may be stopped at any time and may
+// change the list.
+func (cl *ConcurrentList[T]) Iter() iter.Seq[T] {
+	cl.mu.RLock()
+	values := make([]T, 0, cl.len)
+	for e := cl.front(); e != nil; e = cl.next(e) {
+		values = append(values, e.Value)
+	}
+	cl.mu.RUnlock()
+
+	return func(yield func(T) bool) {
+		for _, v := range values {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// front returns the first element or nil, it must be called with the lock held
+func (cl *ConcurrentList[T]) front() *Element[T] {
+	if cl.len == 0 {
+		return nil
+	}
+	return cl.root.next
+}
+
+// next returns the element after e or nil, it must be called with the lock held
+func (cl *ConcurrentList[T]) next(e *Element[T]) *Element[T] {
+	if e.next == &cl.root {
+		return nil
+	}
+	return e.next
+}
+
+// remove unlinks e, it must be called with the write lock held
+func (cl *ConcurrentList[T]) remove(e *Element[T]) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.next = nil
+	e.prev = nil
+	e.list = nil
+	cl.len--
+	if cl.index != nil {
+		cl.index.remove(e)
+	}
 }
@@ -3,12 +3,13 @@ package clist
 import (
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestAppend(t *testing.T) {
 	var wg sync.WaitGroup
 
-	cl := New()
+	cl := New[int]()
 	go func(wg *sync.WaitGroup) {
 		defer wg.Done()
 		cl.PushBack(1)
@@ -24,12 +25,15 @@ func TestAppend(t *testing.T) {
 	wg.Add(1)
 
 	wg.Wait()
+	if cl.Len() != 2 {
+		t.Errorf("Len returned %d, expected 2", cl.Len())
+	}
 }
 
 func TestIter(t *testing.T) {
 	var wg sync.WaitGroup
 
-	cl := New()
+	cl := New[any]()
 
 	go func(wg *sync.WaitGroup) {
 		defer wg.Done()
@@ -47,16 +51,248 @@ func TestIter(t *testing.T) {
 
 	cl.PushBack("Hello")
 
-	c := cl.Iter()
-	for e := range c {
-		t.Logf("%v\n", e.Value)
+	for v := range cl.Iter() {
+		t.Logf("%v\n", v)
 	}
 
 	t.Logf("Waiting for all goroutines\n")
 	wg.Wait()
 
-	c = cl.Iter()
-	for e := range c {
-		t.Logf("%v\n", e.Value)
+	n := 0
+	for v := range cl.Iter() {
+		t.Logf("%v\n", v)
+		n++
+	}
+	if n != 4 {
+		t.Errorf("Iter returned %d values, expected 4", n)
+	}
+}
+
+func TestIterAbandoned(t *testing.T) {
+	var cl ConcurrentList[int]
+	for i := 0; i < 10; i++ {
+		cl.PushBack(i)
+	}
+
+	// the list is not locked by an iteration that is stopped or changes the list
+	for v := range cl.Iter() {
+		cl.Remove(v)
+		break
+	}
+	for v := range cl.Iter() {
+		cl.PushBack(v)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cl.PushBack(10)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("PushBack blocked after an abandoned iteration")
+	}
+	if cl.Len() != 19 {
+		t.Errorf("Len returned %d, expected 19", cl.Len())
+	}
+}
+
+func TestRangeFindRemoveFunc(t *testing.T) {
+	cl := New[int]()
+	for i := 0; i < 10; i++ {
+		cl.PushBack(i)
+	}
+
+	var values []int
+	cl.Range(func(v int) bool {
+		values = append(values, v)
+		return v < 3
+	})
+	if len(values) != 4 || values[3] != 3 {
+		t.Errorf("Range visited %v, expected [0 1 2 3]", values)
+	}
+
+	if v, ok := cl.Find(func(v int) bool { return v > 4 }); !ok || v != 5 {
+		t.Errorf("Find returned %d, %v, expected 5, true", v, ok)
+	}
+	if _, ok := cl.Find(func(v int) bool { return v > 9 }); ok {
+		t.Errorf("Find found a missing value")
+	}
+
+	if n := cl.RemoveFunc(func(v int) bool { return v%2 == 0 }); n != 5 {
+		t.Errorf("RemoveFunc removed %d values, expected 5", n)
+	}
+	if !cl.Remove(9) || cl.Remove(9) {
+		t.Errorf("Remove must find 9 only once")
+	}
+	values = values[:0]
+	for v := range cl.Iter() {
+		values = append(values, v)
+	}
+	if len(values) != 4 || values[0] != 1 || values[3] != 7 {
+		t.Errorf("list holds %v, expected [1 3 5 7]", values)
+	}
+}
+
+// TestConcurrent is meant to be run with the race detector
+func TestConcurrent(t *testing.T) {
+	const (
+		workers = 8
+		rounds  = 500
+	)
+	cl := New[int]()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				v := w*rounds + i
+				cl.PushBack(v)
+				switch i % 4 {
+				case 0:
+					for range cl.Iter() {
+						break
+					}
+				case 1:
+					cl.Range(func(int) bool { return true })
+				case 2:
+					cl.Find(func(x int) bool { return x == v })
+				case 3:
+					cl.RemoveFunc(func(x int) bool { return x == v-1 })
+				}
+				cl.Len()
+			}
+			cl.Remove(w * rounds)
+		}(w)
+	}
+	wg.Wait()
+
+	// a quarter of the values were removed by RemoveFunc and one per worker by Remove
+	if n := cl.Len(); n != workers*(rounds-rounds/4-1) {
+		t.Errorf("Len returned %d, expected %d", n, workers*(rounds-rounds/4-1))
+	}
+}
+
+func TestRemoveElement(t *testing.T) {
+	cl := New[int]()
+	elements := make([]*Element[int], 5)
+	for i := range elements {
+		elements[i] = cl.PushBack(i)
+	}
+
+	if !cl.RemoveElement(elements[2]) || !cl.RemoveElement(elements[0]) || !cl.RemoveElement(elements[4]) {
+		t.Fatal("RemoveElement did not find a value of the list")
+	}
+	if cl.RemoveElement(elements[2]) {
+		t.Error("RemoveElement removed a value twice")
+	}
+	if New[int]().RemoveElement(elements[1]) {
+		t.Error("RemoveElement removed the element of another list")
+	}
+
+	var values []int
+	for v := range cl.Iter() {
+		values = append(values, v)
+	}
+	if len(values) != 2 || values[0] != 1 || values[1] != 3 || cl.Len() != 2 {
+		t.Errorf("list holds %v, expected [1 3]", values)
+	}
+}
+
+type item struct {
+	name string
+}
+
+func TestLookup(t *testing.T) {
+	cl := NewIndexed(func(v *item) string { return v.name })
+	a, b := &item{"a"}, &item{"b"}
+	cl.PushBack(a)
+	eb := cl.PushBack(b)
+
+	if e, ok := Lookup(cl, "b"); !ok || e.Value != b {
+		t.Errorf("Lookup(b) returned %v, %v", e, ok)
+	}
+	if _, ok := Lookup(cl, 1); ok {
+		t.Error("Lookup found a key of another type")
+	}
+	if _, ok := Lookup(New[*item](), "a"); ok {
+		t.Error("Lookup found a key in a list without index")
+	}
+
+	cl.RemoveElement(eb)
+	if _, ok := Lookup(cl, "b"); ok {
+		t.Error("Lookup found a removed value")
+	}
+	cl.Remove(a)
+	if _, ok := Lookup(cl, "a"); ok {
+		t.Error("Lookup found a removed value")
+	}
+}
+
+const benchmarkSize = 4096
+
+// benchmarkRemove removes and pushes back every value of a list of benchmarkSize
+// values with the given remove function
+func benchmarkRemove(b *testing.B, cl *ConcurrentList[int], remove func(e *Element[int]) bool) {
+	elements := make([]*Element[int], benchmarkSize)
+	for i := range elements {
+		elements[i] = cl.PushBack(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// spread the removals over the list
+		j := i * 1031 % benchmarkSize
+		if !remove(elements[j]) {
+			b.Fatal("value is missing")
+		}
+		elements[j] = cl.PushBack(j)
+	}
+}
+
+func BenchmarkRemove(b *testing.B) {
+	cl := New[int]()
+	benchmarkRemove(b, cl, func(e *Element[int]) bool { return cl.Remove(e.Value) })
+}
+
+func BenchmarkRemoveElement(b *testing.B) {
+	cl := New[int]()
+	benchmarkRemove(b, cl, cl.RemoveElement)
+}
+
+func BenchmarkRemoveElementIndexed(b *testing.B) {
+	cl := NewIndexed(func(v int) int { return v })
+	benchmarkRemove(b, cl, cl.RemoveElement)
+}
+
+func BenchmarkFind(b *testing.B) {
+	cl := New[int]()
+	for i := 0; i < benchmarkSize; i++ {
+		cl.PushBack(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := i % benchmarkSize
+		if _, ok := cl.Find(func(v int) bool { return v == key }); !ok {
+			b.Fatal("value is missing")
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	cl := NewIndexed(func(v int) int { return v })
+	for i := 0; i < benchmarkSize; i++ {
+		cl.PushBack(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := Lookup(cl, i%benchmarkSize); !ok {
+			b.Fatal("value is missing")
+		}
 	}
 }
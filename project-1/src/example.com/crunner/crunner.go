@@ -1,7 +1,14 @@
 package main
 
 import (
+    "bufio"
     "fmt"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+
+    "example.com/p1client"
 )
 
 const (
@@ -9,11 +16,32 @@ const (
     defaultPort = 9999
 )
 
-// To test your server implementation, you might find it helpful to implement a
-// simple 'client runner' program. The program could be very simple, as long as
-// it is able to connect with and send messages to your server and is able to
-// read and print out the server's response to standard output. Whether or
-// not you add any code to this file will not affect your grade.
+// A simple 'client runner' program that reads commands like 'get key' or
+// 'set key value' from standard input, sends them to the server and prints
+// out the server's response to standard output.
 func main() {
-    fmt.Println("Not implemented.")
+    client, err := p1client.Dial(net.JoinHostPort(defaultHost, strconv.Itoa(defaultPort)))
+    if err != nil {
+        fmt.Printf("Failed to connect: %s\n", err)
+        return
+    }
+    defer client.Close()
+
+    scanner := bufio.NewScanner(os.Stdin)
+    for scanner.Scan() {
+        args := strings.SplitN(scanner.Text(), " ", 3)
+        switch {
+        case args[0] == "get" && len(args) == 2:
+            value, err := client.Get(args[1])
+            fmt.Println(string(value), err)
+        case args[0] == "set" && len(args) == 3:
+            fmt.Println(client.Set(args[1], []byte(args[2])))
+        case args[0] == "del" && len(args) == 2:
+            fmt.Println(client.Del(args[1]))
+        case args[0] == "exists" && len(args) == 2:
+            fmt.Println(client.Exists(args[1]))
+        default:
+            fmt.Println("usage: get key | set key value | del key | exists key")
+        }
+    }
 }
@@ -0,0 +1,129 @@
+package p1
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSigned writes a self-signed certificate for localhost into dir and
+// returns the paths of the certificate and its key with a pool trusting it.
+func selfSigned(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPem)
+	return certFile, keyFile, pool
+}
+
+// dialTLS connects a new test client over TLS to the given address
+func dialTLS(t *testing.T, hostport string, config *tls.Config) (*testClient, *bufio.Reader) {
+	conn, err := tls.Dial("tcp", hostport, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{conn: conn}, bufio.NewReader(conn)
+}
+
+func TestAuth(t *testing.T) {
+	server, hostport := startServerWithOptions(t, Options{Token: "secret"})
+	defer server.Close()
+	a, ar := dial(t, hostport)
+	defer a.conn.Close()
+	b, br := dial(t, hostport)
+	defer b.conn.Close()
+
+	exchange(t, a, ar,
+		"auth,secret\n", "auth,,1\n",
+		"set,k,v\n", "",
+	)
+	// commands before a successful auth are refused and
+	// broadcasts are not sent to unauthenticated clients
+	exchange(t, b, br,
+		"set,k,stolen\n", "auth,,0\n",
+		"get,k\n", "auth,,0\n",
+		"replicate,\n", "auth,,0\n",
+		"auth,guess\n", "auth,,0\n",
+		"del,k\n", "auth,,0\n",
+	)
+	exchange(t, a, ar,
+		"get,k\n", "k,v\n",
+	)
+	exchange(t, b, br,
+		"auth,secret\n", "auth,,1\n",
+		"get,k\n", "k,v\n",
+	)
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, pool := selfSigned(t, t.TempDir())
+	config := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	opts := Options{Unicast: true, CertFile: certFile, KeyFile: keyFile, Token: "secret"}
+
+	primary, primaryAddr := startServerWithOptions(t, opts)
+	defer primary.Close()
+	cli, reader := dialTLS(t, primaryAddr, config)
+	defer cli.conn.Close()
+
+	exchange(t, cli, reader,
+		"auth,secret\n", "auth,,1\n",
+		"set,k,v\n", "",
+		"get,k\n", "k,v\n",
+	)
+
+	// a backup authenticates to its primary over TLS
+	opts.Primary = primaryAddr
+	opts.PrimaryTLS = config
+	backup, backupAddr := startServerWithOptions(t, opts)
+	defer backup.Close()
+	bc, br := dialTLS(t, backupAddr, config)
+	defer bc.conn.Close()
+
+	exchange(t, bc, br,
+		"auth,secret\n", "auth,,1\n",
+		"get,k\n", "k,v\n",
+	)
+}
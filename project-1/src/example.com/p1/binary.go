@@ -0,0 +1,146 @@
+// Length-prefixed binary framing of the key value protocol
+
+package p1
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// BinaryHandshake is the first byte sent by clients of the binary protocol.
+// Commands of the text protocol never start with it.
+const BinaryHandshake byte = 0x80
+
+// MaxFieldSize bounds the size of a single field of a frame
+const MaxFieldSize = 1 << 26
+
+// A frame is a uvarint number of fields followed by every field as a uvarint
+// length and its bytes. Requests carry the command name and then its arguments
+// in the order of the text protocol, replies always carry command, key and value
+// where get replies have the get command.
+
+// readFrame reads the fields of a frame
+func readFrame(reader *bufio.Reader) ([][]byte, error) {
+	n, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if n > 4 {
+		return nil, errors.New("[p1] binary: too many fields")
+	}
+
+	fields := make([][]byte, n)
+	for i := range fields {
+		l, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if l > MaxFieldSize {
+			return nil, errors.New("[p1] binary: field is too large")
+		}
+		fields[i] = make([]byte, l)
+		if _, err := io.ReadFull(reader, fields[i]); err != nil {
+			return nil, unexpected(err)
+		}
+	}
+
+	return fields, nil
+}
+
+// writeFrame writes the given fields as a frame
+func writeFrame(writer *bufio.Writer, fields ...[]byte) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	n := binary.PutUvarint(buf, uint64(len(fields)))
+	if _, err := writer.Write(buf[:n]); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		n := binary.PutUvarint(buf, uint64(len(field)))
+		if _, err := writer.Write(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := writer.Write(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseFrame converts the fields of a frame into a request,
+// it returns nil for unknown or malformed commands.
+func parseFrame(fields [][]byte) *request {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	command := string(fields[0])
+	switch {
+	case len(fields) == 1 && (command == "begin" || command == "commit" || command == "abort"):
+		return &request{
+			command: command,
+		}
+	case len(fields) == 2 && (command == "get" || command == "del" || command == "exists" ||
+		command == "mode" || command == "watch" || command == "unwatch" || command == "keys" ||
+		command == "auth"):
+		return &request{
+			command: command,
+			key:     string(fields[1]),
+		}
+	case len(fields) == 3 && command == "set":
+		return &request{
+			command: command,
+			key:     string(fields[1]),
+			value:   fields[2],
+		}
+	case len(fields) == 4 && command == "setex":
+		ms, err := strconv.Atoi(string(fields[2]))
+		if err != nil || ms <= 0 {
+			return nil
+		}
+		return &request{
+			command: command,
+			key:     string(fields[1]),
+			value:   fields[3],
+			ttl:     time.Duration(ms) * time.Millisecond,
+		}
+	case len(fields) == 4 && command == "scan":
+		limit, err := strconv.Atoi(string(fields[3]))
+		if err != nil {
+			return nil
+		}
+		return &request{
+			command: command,
+			key:     string(fields[1]),
+			end:     string(fields[2]),
+			limit:   limit,
+		}
+	case len(fields) == 4 && command == "cas":
+		return &request{
+			command: command,
+			key:     string(fields[1]),
+			old:     fields[2],
+			value:   fields[3],
+		}
+	}
+
+	return nil
+}
+
+// binaryReceiver reads the frames of a binary client
+func (kvs *keyValueServer) binaryReceiver(c *client) {
+	for {
+		fields, err := readFrame(c.reader)
+		if err != nil {
+			return
+		}
+		if r := parseFrame(fields); r != nil {
+			kvs.handle(c, r)
+		}
+	}
+}
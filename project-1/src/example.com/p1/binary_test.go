@@ -0,0 +1,67 @@
+package p1
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBinaryProtocol(t *testing.T) {
+	ts := newTestSystem(t)
+	if err := ts.startServer(startServerTries); err != nil {
+		t.Fatalf("Failed to start server: %s\n", err)
+	}
+	defer ts.server.Close()
+
+	cli := newTestClients(1, false)[0]
+	if err := ts.startClients(cli); err != nil {
+		t.Fatalf("Failed to start clients: %s\n", err)
+	}
+	defer ts.killClients(cli)
+
+	reader := bufio.NewReader(cli.conn)
+	writer := bufio.NewWriter(cli.conn)
+	writer.WriteByte(BinaryHandshake)
+
+	value := []byte("a,b\nc\x00d")
+	requests := [][][]byte{
+		{[]byte("set"), []byte("k,1"), value},
+		{[]byte("get"), []byte("k,1")},
+		{[]byte("unknown")},
+		{[]byte("cas"), []byte("k,1"), value, []byte("\n")},
+		{[]byte("get"), []byte("k,1")},
+		{[]byte("del"), []byte("k,1")},
+	}
+	replies := [][][]byte{
+		{[]byte("get"), []byte("k,1"), value},
+		{[]byte("cas"), []byte("k,1"), []byte("1")},
+		{[]byte("get"), []byte("k,1"), []byte("\n")},
+		{[]byte("del"), []byte("k,1"), []byte("1")},
+	}
+
+	for _, r := range requests {
+		if err := writeFrame(writer, r...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	cli.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, expected := range replies {
+		fields, err := readFrame(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fields) != len(expected) {
+			t.Fatalf("got %q, expected %q", fields, expected)
+		}
+		for i := range fields {
+			if !bytes.Equal(fields[i], expected[i]) {
+				t.Fatalf("got %q, expected %q", fields, expected)
+			}
+		}
+	}
+}
@@ -2,7 +2,12 @@ package p1
 
 import (
 	"bufio"
+	"fmt"
 	"net"
+	"strings"
+	"sync"
+
+	"../clist"
 )
 
 type client struct {
@@ -10,5 +15,124 @@ type client struct {
 	writer *bufio.Writer
 	reader *bufio.Reader
 	res    chan *request
-	req    chan *request
+	room   chan struct{} // signaled when the sender takes a message from res
+	quit   chan struct{} // closed when the client disconnects
+	done   chan struct{} // closed when the sender returns
+	binary bool          // speaks the length-prefixed protocol
+
+	// elem removes the client from the clients of the server
+	elem *clist.Element[*client]
+
+	// dropped is the number of messages dropped because res was full
+	dropped uint64
+
+	// rmu orders the messages put into res, see deliver
+	rmu sync.Mutex
+
+	// tx queues the requests of an open transaction, it is used only by
+	// the client goroutine
+	tx   []*request
+	inTx bool
+
+	// mu guards unicast, watches and authed which are changed by the
+	// client goroutine and read by the dispatcher
+	mu      sync.Mutex
+	unicast bool
+	watches map[string]bool
+	authed  bool // the client has sent the token of the server
+}
+
+// write formats the message in the protocol of the client into its writer
+func (c *client) write(r *request) error {
+	if c.binary {
+		command := r.command
+		if command == "" {
+			command = "get"
+		}
+		return writeFrame(c.writer, []byte(command), []byte(r.key), r.value)
+	}
+
+	var err error
+	if r.command != "" {
+		_, err = fmt.Fprintf(c.writer, "%s,%s,%s\n", r.command, r.key, r.value)
+	} else {
+		_, err = fmt.Fprintf(c.writer, "%s,%s\n", r.key, r.value)
+	}
+	return err
+}
+
+// read reads until the first occurrence of delim and returns the data without the delimiter
+func (c *client) read(delim byte) ([]byte, error) {
+	buf, err := c.reader.ReadBytes(delim)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:len(buf)-1], nil
+}
+
+func (c *client) isAuthed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.authed
+}
+
+func (c *client) setAuthed(authed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.authed = authed
+}
+
+func (c *client) isUnicast() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.unicast
+}
+
+func (c *client) setUnicast(unicast bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unicast = unicast
+}
+
+// watch adds the key or prefix into watches and reports whether it was new
+func (c *client) watch(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.watches[key] {
+		return false
+	}
+	c.watches[key] = true
+	return true
+}
+
+// unwatch removes the key or prefix from watches and reports whether it was watched
+func (c *client) unwatch(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ok := c.watches[key]
+	delete(c.watches, key)
+	return ok
+}
+
+// watching reports whether the client watches the given key, either directly
+// or by a watched prefix that ends with '*'
+func (c *client) watching(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.watches[key] {
+		return true
+	}
+	for w := range c.watches {
+		if strings.HasSuffix(w, "*") && strings.HasPrefix(key, w[:len(w)-1]) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,294 @@
+package p1
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// exchange writes each request on the connection of cli and expects the
+// corresponding reply to be read back in order by the given reader.
+func exchange(t *testing.T, cli *testClient, reader *bufio.Reader, pairs ...string) {
+	for i := 0; i < len(pairs); i += 2 {
+		if _, err := fmt.Fprint(cli.conn, pairs[i]); err != nil {
+			t.Fatal(err)
+		}
+		if pairs[i+1] == "" {
+			continue
+		}
+		cli.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%q: %s", pairs[i], err)
+		}
+		if line != pairs[i+1] {
+			t.Fatalf("%q: got %q, expected %q", pairs[i], line, pairs[i+1])
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	ts := newTestSystem(t)
+	if err := ts.startServer(startServerTries); err != nil {
+		t.Fatalf("Failed to start server: %s\n", err)
+	}
+	defer ts.server.Close()
+
+	cli := newTestClients(1, false)[0]
+	if err := ts.startClients(cli); err != nil {
+		t.Fatalf("Failed to start clients: %s\n", err)
+	}
+	defer ts.killClients(cli)
+
+	exchange(t, cli, bufio.NewReader(cli.conn),
+		"exists,k\n", "exists,k,0\n",
+		"set,k,v1\n", "",
+		"exists,k\n", "exists,k,1\n",
+		"cas,k,v0,v2\n", "cas,k,0\n",
+		"cas,k,v1,v2\n", "cas,k,1\n",
+		"get,k\n", "k,v2\n",
+		"unknown,k,v\n", "",
+		"del,k\n", "del,k,1\n",
+		"del,k\n", "del,k,0\n",
+		"cas,k,,v3\n", "cas,k,1\n",
+		"get,k\n", "k,v3\n",
+	)
+}
+
+func TestUnicastMode(t *testing.T) {
+	ts := newTestSystem(t)
+	if err := ts.startServer(startServerTries); err != nil {
+		t.Fatalf("Failed to start server: %s\n", err)
+	}
+	defer ts.server.Close()
+
+	clients := newTestClients(2, false)
+	if err := ts.startClients(clients...); err != nil {
+		t.Fatalf("Failed to start clients: %s\n", err)
+	}
+	defer ts.killClients(clients...)
+	a, b := clients[0], clients[1]
+
+	exchange(t, a, bufio.NewReader(a.conn),
+		"mode,multicast\n", "mode,multicast,0\n",
+		"mode,unicast\n", "mode,unicast,1\n",
+		"set,k1,v1\n", "",
+		"get,k1\n", "k1,v1\n",
+	)
+	// b must not see the reply to the get of a
+	exchange(t, b, bufio.NewReader(b.conn),
+		"set,k2,v2\n", "",
+		"get,k2\n", "k2,v2\n",
+	)
+}
+
+func TestWatch(t *testing.T) {
+	ts := newTestSystem(t)
+	if err := ts.startServer(startServerTries); err != nil {
+		t.Fatalf("Failed to start server: %s\n", err)
+	}
+	defer ts.server.Close()
+
+	clients := newTestClients(2, false)
+	if err := ts.startClients(clients...); err != nil {
+		t.Fatalf("Failed to start clients: %s\n", err)
+	}
+	defer ts.killClients(clients...)
+	a, b := clients[0], clients[1]
+	ra, rb := bufio.NewReader(a.conn), bufio.NewReader(b.conn)
+
+	exchange(t, a, ra,
+		"watch,user:*\n", "watch,user:*,1\n",
+		"watch,counter\n", "watch,counter,1\n",
+	)
+	exchange(t, b, rb,
+		"set,other,1\n", "",
+		"set,user:1,alice\n", "",
+		"set,counter,1\n", "",
+		"cas,counter,1,2\n", "cas,counter,1\n",
+	)
+	exchange(t, a, ra,
+		"", "notify,user:1,alice\n",
+		"", "notify,counter,1\n",
+		"", "notify,counter,2\n",
+		"unwatch,counter\n", "unwatch,counter,1\n",
+		"unwatch,counter\n", "unwatch,counter,0\n",
+	)
+	exchange(t, b, rb,
+		"set,counter,3\n", "",
+		"set,user:2,bob\n", "",
+	)
+	exchange(t, a, ra,
+		"", "notify,user:2,bob\n",
+	)
+}
+
+func TestSetex(t *testing.T) {
+	ts := newTestSystem(t)
+	if err := ts.startServer(startServerTries); err != nil {
+		t.Fatalf("Failed to start server: %s\n", err)
+	}
+	defer ts.server.Close()
+
+	cli := newTestClients(1, false)[0]
+	if err := ts.startClients(cli); err != nil {
+		t.Fatalf("Failed to start clients: %s\n", err)
+	}
+	defer ts.killClients(cli)
+	reader := bufio.NewReader(cli.conn)
+
+	exchange(t, cli, reader,
+		"setex,session,500,alice\n", "",
+		"setex,invalid,-1,bob\n", "",
+		"set,user,bob\n", "",
+		"get,session\n", "session,alice\n",
+		"exists,invalid\n", "exists,invalid,0\n",
+	)
+	time.Sleep(2 * expiryInterval)
+	exchange(t, cli, reader,
+		"exists,session\n", "exists,session,1\n",
+	)
+	time.Sleep(500 * time.Millisecond)
+	exchange(t, cli, reader,
+		"get,session\n", "session,\n",
+		"exists,session\n", "exists,session,0\n",
+		"get,user\n", "user,bob\n",
+	)
+}
+
+func TestTransaction(t *testing.T) {
+	server, hostport := startServerWithOptions(t, Options{Unicast: true})
+	defer server.Close()
+	cli, reader := dial(t, hostport)
+	defer cli.conn.Close()
+
+	exchange(t, cli, reader,
+		"commit,\n", "commit,,0\n",
+		"set,k1,v0\n", "",
+		"begin,\n", "begin,,1\n",
+		"begin,\n", "begin,,0\n",
+		"set,k1,v1\n", "",
+		"get,k1\n", "",
+		"del,k2\n", "",
+		"set,k2,v2\n", "",
+		// only set, get and del are queued until commit
+		"exists,k2\n", "exists,k2,0\n",
+		"commit,\n", "k1,v1\n",
+		"", "del,k2,0\n",
+		"", "commit,,1\n",
+		"get,k2\n", "k2,v2\n",
+		"begin,\n", "begin,,1\n",
+		"del,k1\n", "",
+		"abort,\n", "abort,,1\n",
+		"abort,\n", "abort,,0\n",
+		"get,k1\n", "k1,v1\n",
+	)
+}
+
+func TestTransactionAtomic(t *testing.T) {
+	server, hostport := startServerWithOptions(t, Options{Unicast: true})
+	defer server.Close()
+	writer, _ := dial(t, hostport)
+	defer writer.conn.Close()
+	cli, reader := dial(t, hostport)
+	defer cli.conn.Close()
+
+	const rounds = 200
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < rounds; i++ {
+			_, err := fmt.Fprintf(writer.conn, "begin,\nset,a,%d\nset,b,%d\ncommit,\n", i, i)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	for i := 0; i < rounds; i++ {
+		fmt.Fprint(cli.conn, "begin,\nget,a\nget,b\ncommit,\n")
+		cli.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var lines [4]string
+		for j := range lines {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines[j] = line
+		}
+		if lines[1][2:] != lines[2][2:] {
+			t.Fatalf("read %q and %q in one transaction", lines[1], lines[2])
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	server, hostport := startServerWithOptions(t, Options{Unicast: true})
+	defer server.Close()
+	cli, reader := dial(t, hostport)
+	defer cli.conn.Close()
+
+	exchange(t, cli, reader,
+		"set,user:2,bob\n", "",
+		"set,user:1,alice\n", "",
+		"set,user:3,carol\n", "",
+		"set,users,4\n", "",
+		"set,group:1,admins\n", "",
+		"scan,user:1,user:3,0\n", "scan,user:1,alice\n",
+		"", "scan,user:2,bob\n",
+		"", "end,scan,2\n",
+		"scan,user:,,3\n", "scan,user:1,alice\n",
+		"", "scan,user:2,bob\n",
+		"", "scan,user:3,carol\n",
+		"", "end,scan,3\n",
+		"keys,user:\n", "keys,user:1,\n",
+		"", "keys,user:2,\n",
+		"", "keys,user:3,\n",
+		"", "end,keys,3\n",
+		"keys,none\n", "end,keys,0\n",
+		"scan,a,b,x\n", "",
+		"keys,group\n", "keys,group:1,\n",
+		"", "end,keys,1\n",
+	)
+}
+
+// TestLargeScan checks that a scan returning more results than the buffer of
+// the client holds is not cut short.
+func TestLargeScan(t *testing.T) {
+	const n = 2000
+	for _, unicast := range []bool{true, false} {
+		server, hostport := startServerWithOptions(t, Options{Unicast: unicast})
+		cli, reader := dial(t, hostport)
+
+		go func() {
+			w := bufio.NewWriter(cli.conn)
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(w, "set,key_%04d,%d\n", i, i)
+			}
+			fmt.Fprint(w, "scan,key_,,0\n")
+			w.Flush()
+		}()
+
+		cli.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for i := 0; i < n; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("unicast %t: result %d: %s", unicast, i, err)
+			}
+			if expected := fmt.Sprintf("scan,key_%04d,%d\n", i, i); line != expected {
+				t.Fatalf("unicast %t: result %d is %q, expected %q", unicast, i, line, expected)
+			}
+		}
+		if line, err := reader.ReadString('\n'); err != nil || line != fmt.Sprintf("end,scan,%d\n", n) {
+			t.Errorf("unicast %t: scan ended with %q, %v", unicast, line, err)
+		}
+
+		cli.conn.Close()
+		server.Close()
+	}
+}
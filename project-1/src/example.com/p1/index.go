@@ -0,0 +1,102 @@
+package p1
+
+import "math/rand"
+
+// maxLevel bounds the height of the skip list, enough for about 4^16 keys
+const maxLevel = 16
+
+// skipList is the ordered index of the keys of a mapStorage. It is not safe
+// for concurrent use, the storage guards it with its own lock.
+type skipList struct {
+	head  skipNode // sentinel before the first key
+	level int      // number of levels in use
+	rnd   *rand.Rand
+}
+
+type skipNode struct {
+	key  string
+	next []*skipNode
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  skipNode{next: make([]*skipNode, maxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(rand.Int63())),
+	}
+}
+
+// path fills prev with the last node before key on every level and returns
+// the first node whose key is not less than key
+func (l *skipList) path(key string, prev []*skipNode) *skipNode {
+	n := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		if prev != nil {
+			prev[i] = n
+		}
+	}
+	return n.next[0]
+}
+
+// insert adds the key and reports whether it was missing
+func (l *skipList) insert(key string) bool {
+	var prev [maxLevel]*skipNode
+	if n := l.path(key, prev[:]); n != nil && n.key == key {
+		return false
+	}
+
+	// each level holds a quarter of the nodes of the level below
+	level := 1
+	for level < maxLevel && l.rnd.Intn(4) == 0 {
+		level++
+	}
+	for ; l.level < level; l.level++ {
+		prev[l.level] = &l.head
+	}
+
+	n := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = prev[i].next[i]
+		prev[i].next[i] = n
+	}
+	return true
+}
+
+// remove deletes the key and reports whether it was present
+func (l *skipList) remove(key string) bool {
+	var prev [maxLevel]*skipNode
+	n := l.path(key, prev[:])
+	if n == nil || n.key != key {
+		return false
+	}
+
+	for i := range n.next {
+		prev[i].next[i] = n.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	return true
+}
+
+// seek returns the node of the first key that is not less than key, the
+// following keys are reached in order through next[0]
+func (l *skipList) seek(key string) *skipNode {
+	return l.path(key, nil)
+}
+
+// prefixEnd returns the smallest key that is greater than every key with the
+// given prefix, or "" when there is no such key
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package p1
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSkipList(t *testing.T) {
+	l := newSkipList()
+	keys := make(map[string]bool)
+	randGen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key_%d", randGen.Intn(1000))
+		if randGen.Intn(3) == 0 {
+			if l.remove(key) != keys[key] {
+				t.Fatalf("remove(%q) disagrees with the keys", key)
+			}
+			delete(keys, key)
+		} else {
+			if l.insert(key) == keys[key] {
+				t.Fatalf("insert(%q) disagrees with the keys", key)
+			}
+			keys[key] = true
+		}
+	}
+
+	var expected []string
+	for key := range keys {
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+	i := 0
+	for n := l.seek(""); n != nil; n = n.next[0] {
+		if i >= len(expected) || n.key != expected[i] {
+			t.Fatalf("key %d is %q, expected %q", i, n.key, expected[i])
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("skip list has %d keys, expected %d", i, len(expected))
+	}
+}
+
+func TestStorageScan(t *testing.T) {
+	for _, store := range []Storage{NewMapStorage(), NewShardedStorage(defaultShards)} {
+		for i := 0; i < 100; i++ {
+			store.Set(fmt.Sprintf("key_%02d", i), []byte{byte(i)})
+		}
+		store.SetEx("key_50", []byte("expired"), time.Now())
+		store.Del("key_51")
+
+		var keys []string
+		store.Scan("key_45", "key_60", 6, func(key string, value []byte) bool {
+			keys = append(keys, key)
+			return true
+		})
+		expected := []string{"key_45", "key_46", "key_47", "key_48", "key_49", "key_52"}
+		if fmt.Sprint(keys) != fmt.Sprint(expected) {
+			t.Errorf("%T: scanned %q, expected %q", store, keys, expected)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, end := range map[string]string{
+		"":         "",
+		"a":        "b",
+		"ab":       "ac",
+		"a\xff":    "b",
+		"\xff\xff": "",
+	} {
+		if e := prefixEnd(prefix); e != end {
+			t.Errorf("prefixEnd(%q) = %q, expected %q", prefix, e, end)
+		}
+	}
+}
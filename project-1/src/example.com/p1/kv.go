@@ -2,21 +2,133 @@
 
 package p1
 
-var kvstore = make(map[string][]byte)
+import (
+    "bytes"
+    "sync"
+    "time"
+)
 
-// this function instantiates the database
-func createDB() {
-    kvstore = make(map[string][]byte)
+// mapStorage is the basic Storage that guards a single map with one lock
+type mapStorage struct {
+    mu      sync.Mutex
+    kvstore map[string][]byte
+    expires map[string]time.Time // deadline of keys that are set with a ttl
+    index   *skipList            // keys of kvstore in order
 }
 
-// set inserts a new key value pair or updates the value for a
-// given key in the store
-func set(key string, value []byte) {
-    kvstore[key] = value
+// NewMapStorage instantiates a storage that keeps every key in a single map
+func NewMapStorage() Storage {
+    return newMapStorage()
 }
 
-// get fetches the value associated with the key
-func get(key string) []byte {
-    v, _ := kvstore[key]
-    return v
+func newMapStorage() *mapStorage {
+    return &mapStorage{
+        kvstore: make(map[string][]byte),
+        expires: make(map[string]time.Time),
+        index:   newSkipList(),
+    }
+}
+
+func (s *mapStorage) Set(key string, value []byte) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.set(key, value)
+}
+
+func (s *mapStorage) SetEx(key string, value []byte, deadline time.Time) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.kvstore[key] = value
+    s.expires[key] = deadline
+    s.index.insert(key)
+}
+
+func (s *mapStorage) Get(key string) ([]byte, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.lookup(key)
+}
+
+func (s *mapStorage) Del(key string) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    _, ok := s.lookup(key)
+    delete(s.kvstore, key)
+    delete(s.expires, key)
+    s.index.remove(key)
+    return ok
+}
+
+func (s *mapStorage) CAS(key string, old, value []byte) bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    v, _ := s.lookup(key)
+    if !bytes.Equal(v, old) {
+        return false
+    }
+    s.set(key, value)
+    return true
+}
+
+func (s *mapStorage) Expire(now time.Time) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for key, d := range s.expires {
+        if !now.Before(d) {
+            delete(s.kvstore, key)
+            delete(s.expires, key)
+            s.index.remove(key)
+        }
+    }
+}
+
+func (s *mapStorage) Range(fn func(key string, value []byte, deadline time.Time) bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for key, value := range s.kvstore {
+        if !fn(key, value, s.expires[key]) {
+            return
+        }
+    }
+}
+
+func (s *mapStorage) Scan(start, end string, limit int, fn func(key string, value []byte) bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for n := s.index.seek(start); n != nil && (end == "" || n.key < end); n = n.next[0] {
+        value, ok := s.lookup(n.key)
+        if !ok {
+            continue
+        }
+        if !fn(n.key, value) {
+            return
+        }
+        if limit--; limit == 0 {
+            return
+        }
+    }
+}
+
+// set must be called with the lock held
+func (s *mapStorage) set(key string, value []byte) {
+    s.kvstore[key] = value
+    delete(s.expires, key)
+    s.index.insert(key)
+}
+
+// lookup must be called with the lock held
+func (s *mapStorage) lookup(key string) ([]byte, bool) {
+    if d, ok := s.expires[key]; ok && !time.Now().Before(d) {
+        return nil, false
+    }
+    v, ok := s.kvstore[key]
+    return v, ok
 }
@@ -0,0 +1,137 @@
+package p1
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// commands are the commands counted by the metrics
+var commands = []string{
+	"get", "set", "setex", "del", "exists", "cas", "mode", "watch", "unwatch",
+	"scan", "keys", "begin", "commit", "abort", "auth", "replicate",
+}
+
+// metrics are the counters of a server exposed on its admin endpoint
+type metrics struct {
+	bytesIn  uint64
+	bytesOut uint64
+	dropped  uint64 // messages dropped for slow clients
+
+	// commands counts the requests by command, the map is not changed
+	// after it is created
+	commands map[string]*uint64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		commands: make(map[string]*uint64),
+	}
+	for _, command := range commands {
+		m.commands[command] = new(uint64)
+	}
+	return m
+}
+
+// count counts a request of the given command
+func (m *metrics) count(command string) {
+	if n, ok := m.commands[command]; ok {
+		atomic.AddUint64(n, 1)
+	}
+}
+
+// countingListener counts the bytes read and written by its connections
+type countingListener struct {
+	net.Listener
+	m *metrics
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, m: l.m}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	m *metrics
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.m.bytesIn, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.m.bytesOut, uint64(n))
+	return n, err
+}
+
+// admin serves the metrics on the given listener until Close
+func (kvs *keyValueServer) admin(ln net.Listener) {
+	defer kvs.wg.Done()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", kvs.serveMetrics)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-kvs.ctx.Done()
+		srv.Close()
+	}()
+	srv.Serve(ln)
+}
+
+// serveMetrics writes the metrics in the Prometheus text format
+func (kvs *keyValueServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var keys, size int
+	kvs.store.Range(func(key string, value []byte, deadline time.Time) bool {
+		keys++
+		size += len(key) + len(value)
+		return true
+	})
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP p1_clients Number of connected clients.")
+	fmt.Fprintln(w, "# TYPE p1_clients gauge")
+	fmt.Fprintf(w, "p1_clients %d\n", kvs.clients.Len())
+
+	fmt.Fprintln(w, "# HELP p1_commands_total Number of requests by command.")
+	fmt.Fprintln(w, "# TYPE p1_commands_total counter")
+	names := make([]string, 0, len(kvs.metrics.commands))
+	for command := range kvs.metrics.commands {
+		names = append(names, command)
+	}
+	sort.Strings(names)
+	for _, command := range names {
+		n := atomic.LoadUint64(kvs.metrics.commands[command])
+		fmt.Fprintf(w, "p1_commands_total{command=%q} %d\n", command, n)
+	}
+
+	fmt.Fprintln(w, "# HELP p1_received_bytes_total Number of bytes read from clients.")
+	fmt.Fprintln(w, "# TYPE p1_received_bytes_total counter")
+	fmt.Fprintf(w, "p1_received_bytes_total %d\n", atomic.LoadUint64(&kvs.metrics.bytesIn))
+
+	fmt.Fprintln(w, "# HELP p1_sent_bytes_total Number of bytes written to clients.")
+	fmt.Fprintln(w, "# TYPE p1_sent_bytes_total counter")
+	fmt.Fprintf(w, "p1_sent_bytes_total %d\n", atomic.LoadUint64(&kvs.metrics.bytesOut))
+
+	fmt.Fprintln(w, "# HELP p1_dropped_messages_total Number of messages dropped for slow clients.")
+	fmt.Fprintln(w, "# TYPE p1_dropped_messages_total counter")
+	fmt.Fprintf(w, "p1_dropped_messages_total %d\n", atomic.LoadUint64(&kvs.metrics.dropped))
+
+	fmt.Fprintln(w, "# HELP p1_keys Number of keys in the store.")
+	fmt.Fprintln(w, "# TYPE p1_keys gauge")
+	fmt.Fprintf(w, "p1_keys %d\n", keys)
+
+	fmt.Fprintln(w, "# HELP p1_store_bytes Size of the keys and values in the store.")
+	fmt.Fprintln(w, "# TYPE p1_store_bytes gauge")
+	fmt.Fprintf(w, "p1_store_bytes %d\n", size)
+}
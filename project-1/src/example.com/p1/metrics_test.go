@@ -0,0 +1,58 @@
+package p1
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	// find a free port for the admin listener
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminAddr := ln.Addr().String()
+	ln.Close()
+
+	server, hostport := startServerWithOptions(t, Options{Unicast: true, AdminAddr: adminAddr})
+	defer server.Close()
+	cli, reader := dial(t, hostport)
+	defer cli.conn.Close()
+
+	exchange(t, cli, reader,
+		"set,k1,v1\n", "",
+		"set,k2,v2\n", "",
+		"get,k1\n", "k1,v1\n",
+		"del,k3\n", "del,k3,0\n",
+	)
+
+	res, err := http.Get("http://" + adminAddr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, metric := range []string{
+		"p1_clients 1\n",
+		"p1_commands_total{command=\"set\"} 2\n",
+		"p1_commands_total{command=\"get\"} 1\n",
+		"p1_commands_total{command=\"del\"} 1\n",
+		"p1_commands_total{command=\"cas\"} 0\n",
+		"p1_received_bytes_total 34\n",
+		"p1_sent_bytes_total 15\n",
+		"p1_dropped_messages_total 0\n",
+		"p1_keys 2\n",
+		"p1_store_bytes 8\n",
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("metrics do not contain %q:\n%s", metric, body)
+		}
+	}
+}
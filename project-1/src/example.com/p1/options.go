@@ -0,0 +1,68 @@
+package p1
+
+import "crypto/tls"
+
+// SlowClientPolicy decides what happens to a message for a client
+// whose outgoing buffer is full.
+type SlowClientPolicy int
+
+const (
+	DropNewest SlowClientPolicy = iota // The message is dropped.
+	DropOldest                         // The oldest buffered message is dropped to make room.
+	Disconnect                         // The client is disconnected.
+)
+
+// Options configures a KeyValueServer created by NewWithOptions.
+// The zero value gives the same server as New.
+type Options struct {
+	// Dir is the data directory of the persistent database,
+	// the database lives only in memory when it is empty.
+	Dir string
+
+	// Unicast sends get replies only to the requesting client instead of
+	// broadcasting them to every connected client. Each client can still
+	// switch its own mode with the mode command.
+	Unicast bool
+
+	// Storage is the storage engine of the database,
+	// a sharded storage is used when it is nil.
+	Storage Storage
+
+	// Primary is the address of the server that this server backs up. A backup
+	// gets every update of its primary, rejects updates of its own clients and
+	// is promoted to primary once its primary can no longer be reached.
+	Primary string
+
+	// SlowClient is the policy for clients that do not read their messages
+	// fast enough, messages are dropped for them by default.
+	SlowClient SlowClientPolicy
+
+	// CertFile and KeyFile are the paths of the PEM encoded certificate and
+	// private key of the server. When they are set clients connect over TLS.
+	CertFile string
+	KeyFile  string
+
+	// Token is a secret shared with the clients. When it is not empty a client
+	// must send auth,<token> before the server accepts any other command,
+	// other commands are refused with auth,,0.
+	Token string
+
+	// PrimaryTLS is the TLS configuration used to connect to Primary,
+	// the connection is plain TCP when it is nil. A backup authenticates
+	// to its primary with its own Token.
+	PrimaryTLS *tls.Config
+
+	// AdminAddr is the address of an HTTP listener that serves the metrics
+	// of the server in the Prometheus text format at /metrics. There is no
+	// admin listener when it is empty.
+	AdminAddr string
+}
+
+// DropReporter is implemented by the servers of this package. It reports
+// the messages that were dropped for slow clients next to Count.
+type DropReporter interface {
+	// Dropped returns the number of messages dropped for each connected client
+	// by the address of the client. This method must not be called on an
+	// un-started or closed server.
+	Dropped() map[string]uint64
+}
@@ -0,0 +1,278 @@
+// Primary-backup replication of the key value store
+
+package p1
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// replicationTimeout bounds the time a primary waits for the acknowledgement
+// of a backup before dropping it
+const replicationTimeout = 2 * time.Second
+
+// followDelay is the delay before a backup follows its primary again after a
+// failed attempt
+const followDelay = 100 * time.Millisecond
+
+// ack is written by a backup after applying each record
+const ack byte = 1
+
+// replicationStart is written by the primary before the snapshot, replies
+// sent to the connection before it became a backup precede it
+const replicationStart = "replicate,,1\n"
+
+// snapshotBatch is the number of snapshot records sent before waiting for their
+// acknowledgements, so neither side blocks on a full socket buffer
+const snapshotBatch = 128
+
+// backup is a connection from the primary to one of its backups. Records are
+// framed the same way as in the write-ahead log.
+type backup struct {
+	conn   net.Conn
+	writer *bufio.Writer
+	reader *bufio.Reader
+}
+
+// send writes the given records to the backup and waits for their acknowledgements
+func (b *backup) send(records ...[]byte) error {
+	b.conn.SetDeadline(time.Now().Add(replicationTimeout))
+	for _, record := range records {
+		if _, err := b.writer.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := b.writer.Flush(); err != nil {
+		return err
+	}
+	for range records {
+		a, err := b.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if a != ack {
+			return errors.New("[p1] replication: invalid acknowledgement")
+		}
+	}
+	return nil
+}
+
+// serveBackup takes over the connection of a client that sent the replicate command.
+// The backup receives a snapshot of the whole store and then every applied update.
+// It reports whether the backup was registered.
+func (kvs *keyValueServer) serveBackup(c *client) bool {
+	b := &backup{
+		conn:   c.conn,
+		writer: bufio.NewWriter(c.conn),
+		reader: c.reader,
+	}
+
+	kvs.wmu.Lock()
+	defer kvs.wmu.Unlock()
+
+	// Close has already closed the backups
+	if kvs.ctx.Err() != nil {
+		return false
+	}
+
+	b.conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+	if _, err := b.writer.WriteString(replicationStart); err != nil {
+		return false
+	}
+	if err := b.writer.Flush(); err != nil {
+		return false
+	}
+
+	var records [][]byte
+	kvs.store.Range(func(key string, value []byte, deadline time.Time) bool {
+		records = append(records, snapshotRecord(key, value, deadline))
+		return true
+	})
+	for i := 0; i < len(records); i += snapshotBatch {
+		j := i + snapshotBatch
+		if j > len(records) {
+			j = len(records)
+		}
+		if err := b.send(records[i:j]...); err != nil {
+			log.Printf("[p1] replication: %s\n", err)
+			return false
+		}
+	}
+	kvs.backups = append(kvs.backups, b)
+	return true
+}
+
+// replicate sends an applied update to every backup and drops the backups that
+// fail to acknowledge it. It must be called with wmu held.
+func (kvs *keyValueServer) replicate(op byte, key string, value []byte) {
+	record := encodeRecord(op, key, value)
+
+	backups := kvs.backups[:0]
+	for _, b := range kvs.backups {
+		if err := b.send(record); err != nil {
+			log.Printf("[p1] replication: %s\n", err)
+			b.conn.Close()
+			continue
+		}
+		backups = append(backups, b)
+	}
+	kvs.backups = backups
+}
+
+// follow connects to the given primary and starts applying its updates.
+// The server rejects writes of its own clients until it is promoted.
+func (kvs *keyValueServer) follow(primary string) error {
+	var conn net.Conn
+	var err error
+	if kvs.primaryTLS != nil {
+		conn, err = tls.Dial("tcp", primary, kvs.primaryTLS)
+	} else {
+		conn, err = net.Dial("tcp", primary)
+	}
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	if kvs.token != "" {
+		if err := authenticate(conn, reader, kvs.token); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	// a backup starts from the snapshot of its primary, the store is wiped
+	// before asking for it so the primary does not time out waiting for
+	// the acknowledgements
+	atomic.StoreInt32(&kvs.following, 1)
+	var keys []string
+	kvs.store.Range(func(key string, value []byte, deadline time.Time) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		kvs.store.Del(key)
+	}
+	// the empty snapshot replaces the persisted store, which is rebuilt
+	// from the records of the primary
+	if kvs.wal != nil {
+		if err := kvs.wal.snapshot(); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	if err := awaitSnapshot(conn, reader); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// Close closes the connection unless it is already closing
+	kvs.wmu.Lock()
+	defer kvs.wmu.Unlock()
+	if kvs.ctx.Err() != nil {
+		conn.Close()
+		return errors.New("[p1] server is closed")
+	}
+	kvs.primary = conn
+	kvs.wg.Add(1)
+	go kvs.stream(conn, reader)
+
+	return nil
+}
+
+// authenticate sends the token to the primary and waits for its acceptance
+func authenticate(conn net.Conn, reader *bufio.Reader, token string) error {
+	conn.SetDeadline(time.Now().Add(replicationTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprintf(conn, "auth,%s\n", token); err != nil {
+		return err
+	}
+	// nothing is broadcast to a client before it is authenticated
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != "auth,,1\n" {
+		return errors.New("[p1] replication: primary rejected the token")
+	}
+	return nil
+}
+
+// awaitSnapshot asks the primary to replicate and skips the replies it sent
+// before the connection became a backup
+func awaitSnapshot(conn net.Conn, reader *bufio.Reader) error {
+	conn.SetDeadline(time.Now().Add(replicationTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprint(conn, "replicate,\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line == replicationStart {
+			return nil
+		}
+	}
+}
+
+// stream applies the records sent by the primary until its connection is lost.
+// The primary drops a backup that is too slow while it still takes writes, so
+// the backup follows it again and is promoted to primary only once the primary
+// can no longer be reached.
+func (kvs *keyValueServer) stream(conn net.Conn, reader *bufio.Reader) {
+	defer kvs.wg.Done()
+
+	for {
+		op, key, value, err := readRecord(reader)
+		if err != nil {
+			break
+		}
+
+		kvs.wmu.Lock()
+		apply(kvs.store, op, key, value)
+		if kvs.wal != nil {
+			if err := kvs.wal.append(op, key, value); err != nil {
+				log.Printf("[p1] write-ahead log: %s\n", err)
+			}
+		}
+		kvs.wmu.Unlock()
+
+		if _, err := conn.Write([]byte{ack}); err != nil {
+			break
+		}
+	}
+	conn.Close()
+
+	for kvs.ctx.Err() == nil {
+		err := kvs.follow(kvs.primaryAddr)
+		if err == nil {
+			return
+		}
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			atomic.StoreInt32(&kvs.following, 0)
+			return
+		}
+		log.Printf("[p1] replication: %s\n", err)
+
+		select {
+		case <-time.After(followDelay):
+		case <-kvs.ctx.Done():
+		}
+	}
+}
+
+// isBackup reports whether the server follows a primary
+func (kvs *keyValueServer) isBackup() bool {
+	return atomic.LoadInt32(&kvs.following) == 1
+}
@@ -0,0 +1,264 @@
+package p1
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startServerWithOptions starts a server configured by opts on a random port
+// and returns it with its address.
+func startServerWithOptions(t *testing.T, opts Options) (KeyValueServer, string) {
+	randGen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < startServerTries; i++ {
+		server := NewWithOptions(opts)
+		port := 2000 + randGen.Intn(10000)
+		if err := server.Start(port); err == nil {
+			return server, net.JoinHostPort("localhost", strconv.Itoa(port))
+		}
+	}
+	t.Fatalf("failed to start server after %d tries", startServerTries)
+	return nil, ""
+}
+
+// dial connects a new test client to the given address
+func dial(t *testing.T, hostport string) (*testClient, *bufio.Reader) {
+	ts := &testSystem{test: t, hostport: hostport}
+	cli := &testClient{}
+	if err := ts.startClients(cli); err != nil {
+		t.Fatalf("Failed to start clients: %s\n", err)
+	}
+	return cli, bufio.NewReader(cli.conn)
+}
+
+func TestReplication(t *testing.T) {
+	primary, primaryAddr := startServerWithOptions(t, Options{Unicast: true})
+	pc, pr := dial(t, primaryAddr)
+	defer pc.conn.Close()
+
+	// a key set before the backup starts arrives with the snapshot
+	exchange(t, pc, pr,
+		"set,before,1\n", "",
+		"get,before\n", "before,1\n",
+	)
+
+	backup, backupAddr := startServerWithOptions(t, Options{Unicast: true, Primary: primaryAddr})
+	defer backup.Close()
+	bc, br := dial(t, backupAddr)
+	defer bc.conn.Close()
+
+	// wait until the primary has registered the backup
+	time.Sleep(200 * time.Millisecond)
+
+	exchange(t, pc, pr,
+		"set,after,2\n", "",
+		"setex,session,60000,3\n", "",
+		"cas,after,2,4\n", "cas,after,1\n",
+	)
+	// the cas reply is sent only after the backup has acknowledged it
+	exchange(t, bc, br,
+		"get,before\n", "before,1\n",
+		"get,after\n", "after,4\n",
+		"get,session\n", "session,3\n",
+		"set,after,5\n", "",
+		"del,after\n", "del,after,0\n",
+		"get,after\n", "after,4\n",
+	)
+
+	primary.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	// the backup is promoted after losing its primary
+	exchange(t, bc, br,
+		"set,after,6\n", "",
+		"get,after\n", "after,6\n",
+		"del,before\n", "del,before,1\n",
+	)
+}
+
+// TestReplicationWithReaders starts a backup while the clients of its primary
+// receive a flood of broadcast get replies, none of which must reach the
+// replication stream.
+func TestReplicationWithReaders(t *testing.T) {
+	primary, primaryAddr := startServerWithOptions(t, Options{})
+	defer primary.Close()
+	pc, pr := dial(t, primaryAddr)
+	defer pc.conn.Close()
+	exchange(t, pc, pr,
+		"set,k,v\n", "",
+		"get,k\n", "k,v\n",
+	)
+
+	rc, _ := dial(t, primaryAddr)
+	defer rc.conn.Close()
+	go io.Copy(io.Discard, rc.conn)
+	stop := make(chan struct{})
+	flooded := make(chan struct{})
+	go func() {
+		defer close(flooded)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := fmt.Fprint(rc.conn, "get,k\n"); err != nil {
+				return
+			}
+		}
+	}()
+
+	backup, backupAddr := startServerWithOptions(t, Options{Unicast: true, Primary: primaryAddr})
+	defer backup.Close()
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	<-flooded
+
+	// the backup still follows its primary
+	fmt.Fprint(pc.conn, "set,after,2\n")
+	time.Sleep(200 * time.Millisecond)
+	bc, br := dial(t, backupAddr)
+	defer bc.conn.Close()
+	exchange(t, bc, br,
+		"get,k\n", "k,v\n",
+		"get,after\n", "after,2\n",
+		"del,k\n", "del,k,0\n",
+	)
+}
+
+// TestReplicationWAL checks that the data directory of a backup keeps only
+// the store of its primary.
+func TestReplicationWAL(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, NewMapStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.append(opSet, "stale", []byte("0"))
+	w.close()
+
+	primary, primaryAddr := startServerWithOptions(t, Options{Unicast: true})
+	defer primary.Close()
+	pc, pr := dial(t, primaryAddr)
+	defer pc.conn.Close()
+	exchange(t, pc, pr,
+		"set,k,v\n", "",
+		"get,k\n", "k,v\n",
+	)
+
+	backup, _ := startServerWithOptions(t, Options{Dir: dir, Primary: primaryAddr})
+	time.Sleep(200 * time.Millisecond)
+	backup.Close()
+
+	store := NewMapStorage()
+	w, err = openWAL(dir, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	if v, ok := store.Get("stale"); ok {
+		t.Errorf("stale recovered as %q", v)
+	}
+	if v, _ := store.Get("k"); string(v) != "v" {
+		t.Errorf("k recovered as %q", v)
+	}
+}
+
+// TestReplicationDropped checks that a backup dropped by its primary, which
+// still takes writes, follows it again instead of being promoted.
+func TestReplicationDropped(t *testing.T) {
+	primary, primaryAddr := startServerWithOptions(t, Options{Unicast: true})
+	defer primary.Close()
+	pc, pr := dial(t, primaryAddr)
+	defer pc.conn.Close()
+	exchange(t, pc, pr,
+		"set,k,v\n", "",
+		"get,k\n", "k,v\n",
+	)
+
+	backup, backupAddr := startServerWithOptions(t, Options{Unicast: true, Primary: primaryAddr})
+	defer backup.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	// as after a backup failed to acknowledge an update in time
+	kvs := primary.(*keyValueServer)
+	kvs.wmu.Lock()
+	for _, b := range kvs.backups {
+		b.conn.Close()
+	}
+	kvs.backups = nil
+	kvs.wmu.Unlock()
+	time.Sleep(200 * time.Millisecond)
+
+	exchange(t, pc, pr,
+		"set,after,2\n", "",
+		"get,after\n", "after,2\n",
+	)
+	bc, br := dial(t, backupAddr)
+	defer bc.conn.Close()
+	exchange(t, bc, br,
+		"get,k\n", "k,v\n",
+		"get,after\n", "after,2\n",
+		"del,k\n", "del,k,0\n",
+	)
+}
+
+// TestReplicationTransaction checks that the readers of a backup never see
+// part of a transaction committed on its primary.
+func TestReplicationTransaction(t *testing.T) {
+	primary, primaryAddr := startServerWithOptions(t, Options{Unicast: true})
+	defer primary.Close()
+	backup, backupAddr := startServerWithOptions(t, Options{Unicast: true, Primary: primaryAddr})
+	defer backup.Close()
+	writer, _ := dial(t, primaryAddr)
+	defer writer.conn.Close()
+	cli, reader := dial(t, backupAddr)
+	defer cli.conn.Close()
+
+	// wait until the primary has registered the backup
+	time.Sleep(200 * time.Millisecond)
+
+	const rounds = 200
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < rounds; i++ {
+			_, err := fmt.Fprintf(writer.conn, "begin,\nset,a,%d\nset,b,%d\ncommit,\n", i, i)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	for i := 0; i < rounds; i++ {
+		// a scan reads both keys at once
+		fmt.Fprint(cli.conn, "scan,a,c,0\n")
+		cli.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line[:4] == "end," {
+				break
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) == 2 && lines[0][7:] != lines[1][7:] {
+			t.Fatalf("read %q and %q of one transaction", lines[0], lines[1])
+		}
+		if len(lines) == 1 {
+			t.Fatalf("read %q without the other key of its transaction", lines[0])
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
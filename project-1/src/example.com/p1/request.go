@@ -1,7 +1,16 @@
 package p1
 
+import "time"
+
 type request struct {
 	command string
 	key     string
 	value   []byte
+	old     []byte        // expected value of a cas command
+	ttl     time.Duration // time to live of a setex command
+	end     string        // end of the key range of a scan command
+	limit   int           // maximum number of keys of a scan command
+	to      *client       // the only receiver of a reply that passes through the dispatcher
+	direct  bool          // a reply to the client, which is never dropped
+	done    chan struct{} // closed by the dispatcher when it reaches the request, see flush
 }
@@ -4,46 +4,237 @@ package p1
 
 import (
 	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"../clist"
 )
 
 type keyValueServer struct {
 	ln      net.Listener
-	clients *clist.ConcurrentList
+	clients *clist.ConcurrentList[*client]
+
+	store   Storage
+	dir     string
+	wal     *wal
+	unicast bool
+
+	// wmu orders updates that must be logged or replicated, other
+	// updates only read lock it and run in parallel
+	wmu     sync.RWMutex
+	backups []*backup
+
+	primaryAddr string
+	primaryTLS  *tls.Config
+	primary     net.Conn
+	following   int32
+
+	certFile string
+	keyFile  string
+	token    string
+
+	slowClient SlowClientPolicy
+
+	adminAddr string
+	metrics   *metrics
+
+	// watches is the number of watched keys over all clients
+	watches int64
+
+	broadcast chan *request
+
+	// ctx is canceled by Close to stop every goroutine of the server
+	// and wg waits for them to return
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	stopped chan struct{} // closed when the dispatcher returns
 }
 
+const (
+	// expiryInterval is the period of removing expired keys from the store
+	expiryInterval = 100 * time.Millisecond
+
+	// handshakeTimeout is the time a new client has to choose the binary protocol
+	handshakeTimeout = 100 * time.Millisecond
+
+	// tlsTimeout bounds the TLS handshake of a new client
+	tlsTimeout = 5 * time.Second
+
+	// drainTimeout bounds the time Close spends writing the pending messages of a client
+	drainTimeout = time.Second
+)
+
 // New creates and returns (but does not start) a new KeyValueServer.
 func New() KeyValueServer {
+	return NewWithOptions(Options{})
+}
+
+// NewWithDir creates and returns (but does not start) a new KeyValueServer
+// that persists its database into the given data directory. Start recovers
+// the database from the directory before accepting any client.
+func NewWithDir(dir string) KeyValueServer {
+	return NewWithOptions(Options{Dir: dir})
+}
+
+// NewWithOptions creates and returns (but does not start) a new KeyValueServer
+// configured by the given options.
+func NewWithOptions(opts Options) KeyValueServer {
+	store := opts.Storage
+	if store == nil {
+		store = NewShardedStorage(defaultShards)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &keyValueServer{
-		clients: clist.New(),
+		clients: clist.New[*client](),
+		store:   store,
+		dir:     opts.Dir,
+		unicast: opts.Unicast,
+
+		primaryAddr: opts.Primary,
+		primaryTLS:  opts.PrimaryTLS,
+		slowClient:  opts.SlowClient,
+
+		certFile: opts.CertFile,
+		keyFile:  opts.KeyFile,
+		token:    opts.Token,
+
+		adminAddr: opts.AdminAddr,
+		metrics:   newMetrics(),
+
+		broadcast: make(chan *request, 500),
+
+		ctx:     ctx,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
 	}
 }
 
 func (kvs *keyValueServer) Start(port int) error {
+	if kvs.ctx.Err() != nil {
+		return errors.New("[p1] server is closed")
+	}
+
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return err
 	}
+	ln = &countingListener{Listener: ln, m: kvs.metrics}
+	if kvs.certFile != "" || kvs.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(kvs.certFile, kvs.keyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+	var adminLn net.Listener
+	if kvs.adminAddr != "" {
+		if adminLn, err = net.Listen("tcp", kvs.adminAddr); err != nil {
+			ln.Close()
+			return err
+		}
+	}
 	kvs.ln = ln
-	createDB()
+	if kvs.dir != "" {
+		w, err := openWAL(kvs.dir, kvs.store)
+		if err != nil {
+			ln.Close()
+			if adminLn != nil {
+				adminLn.Close()
+			}
+			return err
+		}
+		kvs.wal = w
+	}
+	if kvs.primaryAddr != "" {
+		if err := kvs.follow(kvs.primaryAddr); err != nil {
+			ln.Close()
+			if adminLn != nil {
+				adminLn.Close()
+			}
+			if kvs.wal != nil {
+				kvs.wal.close()
+			}
+			return err
+		}
+	}
+	kvs.wg.Add(3)
 	go kvs.dispatch()
+	go kvs.expirer()
 	go kvs.listen()
+	if adminLn != nil {
+		kvs.wg.Add(1)
+		go kvs.admin(adminLn)
+	}
 	return nil
 }
 
+// Close stops accepting clients, writes the messages still buffered for each
+// client before closing its connection and returns once every goroutine of
+// the server has returned. Calling Close more than once has no effect.
 func (kvs *keyValueServer) Close() {
+	if kvs.ctx.Err() != nil {
+		return
+	}
+	kvs.cancel()
+	if kvs.ln == nil {
+		return
+	}
 	kvs.ln.Close()
+	// unblock the senders writing to clients that do not read
+	for c := range kvs.clients.Iter() {
+		c.conn.SetWriteDeadline(time.Now().Add(drainTimeout))
+	}
+
+	kvs.wmu.Lock()
+	if kvs.primary != nil {
+		kvs.primary.Close()
+	}
+	for _, b := range kvs.backups {
+		b.conn.Close()
+	}
+	kvs.backups = nil
+	kvs.wmu.Unlock()
+
+	kvs.wg.Wait()
+
+	kvs.wmu.Lock()
+	defer kvs.wmu.Unlock()
+
+	if kvs.wal != nil {
+		kvs.wal.close()
+		kvs.wal = nil
+	}
 }
 
 func (kvs *keyValueServer) Count() int {
 	return kvs.clients.Len()
 }
 
+// Dropped returns the number of messages dropped for each connected client
+// by the address of the client.
+func (kvs *keyValueServer) Dropped() map[string]uint64 {
+	dropped := make(map[string]uint64)
+	for c := range kvs.clients.Iter() {
+		dropped[c.conn.RemoteAddr().String()] = atomic.LoadUint64(&c.dropped)
+	}
+	return dropped
+}
+
 func (kvs *keyValueServer) listen() {
+	defer kvs.wg.Done()
+
 	for {
 		conn, err := kvs.ln.Accept()
 		if err != nil {
@@ -51,102 +242,639 @@ func (kvs *keyValueServer) listen() {
 		}
 
 		c := &client{
-			conn:   conn,
-			writer: bufio.NewWriter(conn),
-			reader: bufio.NewReader(conn),
-			req:    make(chan *request, 500),
-			res:    make(chan *request, 500),
+			conn:    conn,
+			writer:  bufio.NewWriter(conn),
+			reader:  bufio.NewReader(conn),
+			res:     make(chan *request, 500),
+			room:    make(chan struct{}, 1),
+			quit:    make(chan struct{}),
+			done:    make(chan struct{}),
+			unicast: kvs.unicast,
+			watches: make(map[string]bool),
 		}
-		kvs.clients.PushBack(c)
-		go kvs.sender(c)
-		go kvs.receiver(c)
+		c.elem = kvs.clients.PushBack(c)
+		kvs.wg.Add(2)
+		go kvs.serve(c)
 	}
 
 }
 
+// serve detects the protocol of a new client by its first byte and starts serving it.
+// A client that sends nothing within handshakeTimeout speaks the text protocol.
+func (kvs *keyValueServer) serve(c *client) {
+	defer kvs.wg.Done()
+
+	// a failed handshake fails every read of the receiver
+	if conn, ok := c.conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(tlsTimeout))
+		conn.Handshake()
+		conn.SetDeadline(time.Time{})
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	b, err := c.reader.Peek(1)
+	c.conn.SetReadDeadline(time.Time{})
+	if err == nil && b[0] == BinaryHandshake {
+		c.reader.ReadByte()
+		c.binary = true
+	}
+	go kvs.sender(c)
+	kvs.receiver(c)
+}
+
+// sender writes the messages of the client until it disconnects. When the
+// server is closed it drains the messages still buffered for the client and
+// closes the connection.
 func (kvs *keyValueServer) sender(c *client) {
+	defer kvs.wg.Done()
+	defer close(c.done)
+
 	for {
-		r := <-c.res
-		c.writer.WriteString(fmt.Sprintf("%s,%s\n", r.key, r.value))
-		c.writer.Flush()
+		select {
+		case r := <-c.res:
+			select {
+			case c.room <- struct{}{}:
+			default:
+			}
+			c.write(r)
+			if kvs.ctx.Err() != nil {
+				// the client was accepted after Close set the deadlines
+				c.conn.SetWriteDeadline(time.Now().Add(drainTimeout))
+			}
+			c.writer.Flush()
+		case <-c.quit:
+			return
+		case <-kvs.ctx.Done():
+			// nothing is delivered once the dispatcher has returned
+			<-kvs.stopped
+			c.conn.SetWriteDeadline(time.Now().Add(drainTimeout))
+			for {
+				select {
+				case r := <-c.res:
+					c.write(r)
+				default:
+					c.writer.Flush()
+					c.conn.Close()
+					return
+				}
+			}
+		}
 	}
 }
 
 func (kvs *keyValueServer) receiver(c *client) {
-
+	backup, detached := false, false
 	defer func() {
-		kvs.clients.Remove(c)
+		if !detached {
+			close(c.quit)
+			kvs.clients.RemoveElement(c.elem)
+		}
+		if !backup {
+			c.conn.Close()
+		}
+		c.mu.Lock()
+		atomic.AddInt64(&kvs.watches, -int64(len(c.watches)))
+		c.mu.Unlock()
 	}()
+	if c.binary {
+		kvs.binaryReceiver(c)
+		return
+	}
 	for {
-		var command, key string
-		var value []byte
-
-		buf, err := c.reader.ReadBytes(',')
+		buf, err := c.read(',')
 		if err != nil {
 			return
 		}
-		command = string(buf[:len(buf)-1])
-		if command == "get" {
-			buf, err := c.reader.ReadBytes('\n')
+		command := string(buf)
+		if command == "get" || command == "del" || command == "exists" || command == "mode" ||
+			command == "watch" || command == "unwatch" || command == "keys" || command == "auth" ||
+			command == "begin" || command == "commit" || command == "abort" {
+			buf, err := c.read('\n')
 			if err != nil {
 				return
 			}
-			key = string(buf[:len(buf)-1])
-			c.req <- &request{
-				command: "get",
-				key:     key,
+			kvs.handle(c, &request{
+				command: command,
+				key:     string(buf),
+			})
+		} else if command == "replicate" {
+			if _, err := c.read('\n'); err != nil {
+				return
+			}
+			kvs.metrics.count(command)
+			if !kvs.authorized(c) {
+				kvs.reply(c, &request{command: "auth"}, false)
+				continue
 			}
+			// the connection belongs to the backup from now on
+			kvs.detach(c)
+			detached = true
+			backup = kvs.serveBackup(c)
+			return
 		} else if command == "set" {
-			buf, err := c.reader.ReadBytes(',')
+			key, err := c.read(',')
 			if err != nil {
 				return
 			}
-			key = string(buf[:len(buf)-1])
-			buf, err = c.reader.ReadBytes('\n')
+			value, err := c.read('\n')
 			if err != nil {
 				return
 			}
-			value = buf[:len(buf)-1]
-			c.req <- &request{
+			kvs.handle(c, &request{
 				command: "set",
-				key:     key,
+				key:     string(key),
+				value:   value,
+			})
+		} else if command == "setex" {
+			key, err := c.read(',')
+			if err != nil {
+				return
+			}
+			ttl, err := c.read(',')
+			if err != nil {
+				return
+			}
+			value, err := c.read('\n')
+			if err != nil {
+				return
+			}
+			ms, err := strconv.Atoi(string(ttl))
+			if err != nil || ms <= 0 {
+				continue
+			}
+			kvs.handle(c, &request{
+				command: "setex",
+				key:     string(key),
+				value:   value,
+				ttl:     time.Duration(ms) * time.Millisecond,
+			})
+		} else if command == "scan" {
+			start, err := c.read(',')
+			if err != nil {
+				return
+			}
+			end, err := c.read(',')
+			if err != nil {
+				return
+			}
+			limit, err := c.read('\n')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(string(limit))
+			if err != nil {
+				continue
+			}
+			kvs.handle(c, &request{
+				command: "scan",
+				key:     string(start),
+				end:     string(end),
+				limit:   n,
+			})
+		} else if command == "cas" {
+			key, err := c.read(',')
+			if err != nil {
+				return
+			}
+			old, err := c.read(',')
+			if err != nil {
+				return
+			}
+			value, err := c.read('\n')
+			if err != nil {
+				return
+			}
+			kvs.handle(c, &request{
+				command: "cas",
+				key:     string(key),
+				old:     old,
 				value:   value,
+			})
+		} else {
+			// skip the rest of an unknown command
+			if _, err := c.read('\n'); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// detach stops delivering messages to the client and waits for its sender to
+// return, so nothing but the replication stream is written to its connection
+func (kvs *keyValueServer) detach(c *client) {
+	kvs.clients.RemoveElement(c.elem)
+	close(c.quit)
+	<-c.done
+}
+
+// handle applies the given request to the store and routes its replies.
+// The set, get and del requests of an open transaction are queued until commit.
+// Requests of a client that has not authenticated yet are refused with auth,,0.
+func (kvs *keyValueServer) handle(c *client, r *request) {
+	kvs.metrics.count(r.command)
+	if r.command == "auth" {
+		ok := subtle.ConstantTimeCompare([]byte(r.key), []byte(kvs.token)) == 1
+		c.setAuthed(ok)
+		kvs.reply(c, &request{command: r.command}, ok)
+		return
+	}
+	if !kvs.authorized(c) {
+		kvs.reply(c, &request{command: "auth"}, false)
+		return
+	}
+
+	if c.inTx && (r.command == "set" || r.command == "get" || r.command == "del") {
+		c.tx = append(c.tx, r)
+		return
+	}
+
+	switch r.command {
+	case "get":
+		value, _ := kvs.lookup(r.key)
+		kvs.get(c, r.key, value)
+	case "set":
+		ok := kvs.write(opSet, r.key, r.value, func() bool {
+			kvs.store.Set(r.key, r.value)
+			return true
+		})
+		if ok {
+			kvs.notify(r)
+		}
+	case "setex":
+		deadline := time.Now().Add(r.ttl)
+		ok := kvs.write(opSetEx, r.key, expiring(deadline, r.value), func() bool {
+			kvs.store.SetEx(r.key, r.value, deadline)
+			return true
+		})
+		if ok {
+			kvs.notify(r)
+		}
+	case "del":
+		ok := kvs.write(opDel, r.key, nil, func() bool {
+			return kvs.store.Del(r.key)
+		})
+		kvs.reply(c, r, ok)
+	case "exists":
+		_, ok := kvs.lookup(r.key)
+		kvs.reply(c, r, ok)
+	case "cas":
+		ok := kvs.write(opSet, r.key, r.value, func() bool {
+			return kvs.store.CAS(r.key, r.old, r.value)
+		})
+		if ok {
+			kvs.notify(r)
+		}
+		kvs.reply(c, r, ok)
+	case "mode":
+		ok := r.key == "unicast" || r.key == "broadcast"
+		if r.key == "unicast" && !c.isUnicast() {
+			// replies of broadcast mode pass through the dispatcher
+			kvs.flush()
+		}
+		if ok {
+			c.setUnicast(r.key == "unicast")
+		}
+		kvs.reply(c, r, ok)
+	case "watch":
+		if c.watch(r.key) {
+			atomic.AddInt64(&kvs.watches, 1)
+		}
+		kvs.reply(c, r, true)
+	case "unwatch":
+		ok := c.unwatch(r.key)
+		if ok {
+			atomic.AddInt64(&kvs.watches, -1)
+		}
+		kvs.reply(c, r, ok)
+	case "scan":
+		kvs.scan(c, r, r.key, r.end, r.limit)
+	case "keys":
+		kvs.scan(c, r, r.key, prefixEnd(r.key), 0)
+	case "begin":
+		ok := !c.inTx
+		c.inTx = true
+		kvs.reply(c, r, ok)
+	case "abort":
+		ok := c.inTx
+		c.inTx, c.tx = false, nil
+		kvs.reply(c, r, ok)
+	case "commit":
+		ok := c.inTx && kvs.commit(c, c.tx)
+		c.inTx, c.tx = false, nil
+		kvs.reply(c, r, ok)
+	}
+}
+
+// authorized reports whether the server accepts the commands of the client
+func (kvs *keyValueServer) authorized(c *client) bool {
+	return kvs.token == "" || c.isAuthed()
+}
+
+// commit applies the queued requests of a transaction while holding wmu, so
+// other clients see either none or all of its updates, then routes their
+// replies in order. Backups reject transactions of their clients.
+func (kvs *keyValueServer) commit(c *client, tx []*request) bool {
+	if kvs.isBackup() {
+		return false
+	}
+
+	results := make([]bool, len(tx))
+	values := make([][]byte, len(tx))
+
+	// the updates are logged and replicated as a single record,
+	// so backups and recovery never see part of the transaction
+	var batch []byte
+	kvs.wmu.Lock()
+	for i, r := range tx {
+		switch r.command {
+		case "get":
+			values[i], _ = kvs.store.Get(r.key)
+		case "set":
+			kvs.store.Set(r.key, r.value)
+			batch = append(batch, encodeRecord(opSet, r.key, r.value)...)
+			results[i] = true
+		case "del":
+			if results[i] = kvs.store.Del(r.key); results[i] {
+				batch = append(batch, encodeRecord(opDel, r.key, nil)...)
 			}
 		}
 	}
+	if len(batch) > 0 {
+		kvs.record(opBatch, "", batch)
+	}
+	kvs.wmu.Unlock()
+
+	for i, r := range tx {
+		switch r.command {
+		case "get":
+			kvs.get(c, r.key, values[i])
+		case "set":
+			kvs.notify(r)
+		case "del":
+			kvs.reply(c, r, results[i])
+		}
+	}
+	return true
+}
+
+// scan streams the keys in [start, end) in order to the requester, as
+// scan,<key>,<value> or keys,<key>, followed by end,<command>,<count>
+func (kvs *keyValueServer) scan(c *client, r *request, start, end string, limit int) {
+	var results []*request
+	kvs.wmu.RLock()
+	kvs.store.Scan(start, end, limit, func(key string, value []byte) bool {
+		result := &request{
+			command: r.command,
+			key:     key,
+		}
+		if r.command == "scan" {
+			result.value = value
+		}
+		results = append(results, result)
+		return true
+	})
+	kvs.wmu.RUnlock()
+
+	for _, result := range results {
+		kvs.send(c, result)
+	}
+	kvs.send(c, &request{
+		command: "end",
+		key:     r.command,
+		value:   []byte(strconv.Itoa(len(results))),
+	})
+}
+
+// lookup reads the value of the key, it waits for a transaction being committed
+func (kvs *keyValueServer) lookup(key string) ([]byte, bool) {
+	kvs.wmu.RLock()
+	defer kvs.wmu.RUnlock()
+
+	return kvs.store.Get(key)
+}
+
+// get sends the reply of a get request to the requester in unicast mode
+// or to every client in broadcast mode
+func (kvs *keyValueServer) get(c *client, key string, value []byte) {
+	g := &request{
+		key:   key,
+		value: value,
+	}
+	if c.isUnicast() {
+		kvs.push(c, g)
+	} else {
+		kvs.post(g)
+	}
+}
+
+// write applies an update to the store with the given function and, if it
+// reports a change, appends the update into the write-ahead log and sends it
+// to the backups before returning. Backups reject the updates of their clients.
+func (kvs *keyValueServer) write(op byte, key string, value []byte, apply func() bool) bool {
+	if kvs.isBackup() {
+		return false
+	}
+
+	kvs.wmu.RLock()
+	if kvs.wal == nil && len(kvs.backups) == 0 {
+		defer kvs.wmu.RUnlock()
+		return apply()
+	}
+	kvs.wmu.RUnlock()
+
+	kvs.wmu.Lock()
+	defer kvs.wmu.Unlock()
+
+	if !apply() {
+		return false
+	}
+	kvs.record(op, key, value)
+	return true
 }
 
+// record appends an applied update into the write-ahead log and sends it to
+// the backups. It must be called with wmu held.
+func (kvs *keyValueServer) record(op byte, key string, value []byte) {
+	if kvs.wal != nil {
+		if err := kvs.wal.append(op, key, value); err != nil {
+			log.Printf("[p1] write-ahead log: %s\n", err)
+		}
+	}
+	kvs.replicate(op, key, value)
+}
+
+// dispatch sends get replies to every client in broadcast mode, notifications
+// to their watchers and replies that are addressed to a single client
 func (kvs *keyValueServer) dispatch() {
+	defer kvs.wg.Done()
+	defer close(kvs.stopped)
+
 	for {
-		var res []*request
-		for c := range kvs.clients.Iter() {
-			select {
-			case r := <-c.Value.(*client).req:
-				if r.command == "get" {
-					res = append(res, &request{
-						key:   r.key,
-						value: get(r.key),
-					})
-				}
-				if r.command == "set" {
-					set(r.key, r.value)
-				}
-			default:
+		select {
+		case r := <-kvs.broadcast:
+			if r.done != nil {
+				close(r.done)
+				continue
+			}
+			if r.to != nil {
+				kvs.push(r.to, r)
 				continue
 			}
-		}
-		runtime.Gosched()
-		if len(res) != 0 {
 			for c := range kvs.clients.Iter() {
-				for _, r := range res {
-					select {
-					case c.Value.(*client).res <- r:
-					default:
-						break
-					}
+				// let the senders drain their buffers while the message is
+				// delivered, so only clients that do not read lose messages
+				runtime.Gosched()
+				if r.command == "notify" && !c.watching(r.key) {
+					continue
+				}
+				if r.command == "" && (c.isUnicast() || !kvs.authorized(c)) {
+					continue
 				}
+				kvs.deliver(c, r)
 			}
+		case <-kvs.ctx.Done():
+			return
+		}
+	}
+}
+
+// expirer periodically removes expired keys from the store until the server is closed
+func (kvs *keyValueServer) expirer() {
+	defer kvs.wg.Done()
+
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			kvs.store.Expire(now)
+		case <-kvs.ctx.Done():
+			return
+		}
+	}
+}
 
+// notify sends the notify message of the given update to the dispatcher
+// when any client watches a key
+func (kvs *keyValueServer) notify(r *request) {
+	if atomic.LoadInt64(&kvs.watches) == 0 {
+		return
+	}
+	kvs.post(&request{
+		command: "notify",
+		key:     r.key,
+		value:   r.value,
+	})
+}
+
+// post sends the message to the dispatcher unless the server is closed
+func (kvs *keyValueServer) post(r *request) {
+	select {
+	case kvs.broadcast <- r:
+	case <-kvs.ctx.Done():
+	}
+}
+
+// flush waits until the dispatcher has delivered the messages posted before,
+// so the replies sent directly to a client do not overtake them
+func (kvs *keyValueServer) flush() {
+	done := make(chan struct{})
+	kvs.post(&request{done: done})
+	select {
+	case <-done:
+	case <-kvs.ctx.Done():
+	}
+}
+
+// reply sends the boolean result of the given request only to its requester
+// as <command>,<key>,<1|0>.
+func (kvs *keyValueServer) reply(c *client, r *request, ok bool) {
+	value := []byte("0")
+	if ok {
+		value = []byte("1")
+	}
+	kvs.send(c, &request{
+		command: r.command,
+		key:     r.key,
+		value:   value,
+	})
+}
+
+// send routes a message that is addressed only to the given client
+func (kvs *keyValueServer) send(c *client, rep *request) {
+	// in broadcast mode get replies pass through the dispatcher,
+	// so other replies follow them to keep their order
+	if !c.isUnicast() {
+		rep.to = c
+		kvs.post(rep)
+		return
+	}
+	kvs.push(c, rep)
+}
+
+// push buffers a reply addressed only to the client. Replies are never
+// dropped, as clients match them with their requests in order, so push
+// waits for room until the client disconnects or the server is closed.
+func (kvs *keyValueServer) push(c *client, r *request) {
+	r.direct = true
+	for {
+		c.rmu.Lock()
+		select {
+		case c.res <- r:
+			c.rmu.Unlock()
+			return
+		default:
+		}
+		c.rmu.Unlock()
+
+		select {
+		case <-c.room:
+		case <-c.quit:
+			return
+		case <-kvs.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver buffers a broadcast get or notify message for the client and
+// applies the slow client policy of the server when the buffer is full.
+// Messages are put into res with rmu held, so the oldest broadcast message
+// can be dropped without reordering the others.
+func (kvs *keyValueServer) deliver(c *client, r *request) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	select {
+	case c.res <- r:
+		return
+	default:
+	}
+
+	atomic.AddUint64(&c.dropped, 1)
+	atomic.AddUint64(&kvs.metrics.dropped, 1)
+	switch kvs.slowClient {
+	case DropOldest:
+		// the sender may take some of the messages meanwhile, the others
+		// are put back in order without the oldest broadcast message
+		var buffered []*request
+		dropped := false
+		for len(c.res) > 0 {
+			b := <-c.res
+			if !dropped && !b.direct {
+				dropped = true
+				continue
+			}
+			buffered = append(buffered, b)
+		}
+		if dropped {
+			buffered = append(buffered, r)
+		}
+		for _, b := range buffered {
+			c.res <- b
 		}
+	case Disconnect:
+		c.conn.Close()
 	}
 }
@@ -10,6 +10,7 @@ import (
     "fmt"
     "math/rand"
     "net"
+    "runtime"
     "strconv"
     "syscall"
     "testing"
@@ -536,4 +537,148 @@ func TestSlowClient1(t *testing.T) {
 
 func TestSlowClient2(t *testing.T) {
     testSlowClient(t, "TestSlowClient2", 1000, 4, 2, 5000, 10000)
-}
\ No newline at end of file
+}
+
+// benchmarkStorage measures concurrent gets and sets, one in four, on the
+// same 1024 keys of the given store.
+func benchmarkStorage(b *testing.B, store Storage) {
+    keys := make([]string, 1024)
+    for i := range keys {
+        keys[i] = fmt.Sprintf("key_%d", i)
+        store.Set(keys[i], []byte("value"))
+    }
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        i := rand.Int()
+        for pb.Next() {
+            key := keys[i%len(keys)]
+            if i%4 == 0 {
+                store.Set(key, []byte("value"))
+            } else {
+                store.Get(key)
+            }
+            i++
+        }
+    })
+}
+
+// benchmarkServer measures set/get round trips of concurrent unicast clients.
+func benchmarkServer(b *testing.B, store Storage, numClients int) {
+    randGen := rand.New(rand.NewSource(time.Now().Unix()))
+    server := NewWithOptions(Options{Unicast: true, Storage: store})
+    port := 2000 + randGen.Intn(10000)
+    if err := server.Start(port); err != nil {
+        b.Fatalf("Failed to start server: %s\n", err)
+    }
+    defer server.Close()
+
+    ts := &testSystem{hostport: net.JoinHostPort("localhost", strconv.Itoa(port))}
+    clients := newTestClients(numClients, false)
+    if err := ts.startClients(clients...); err != nil {
+        b.Fatalf("Failed to start clients: %s\n", err)
+    }
+    defer ts.killClients(clients...)
+
+    b.ResetTimer()
+    errs := make(chan error, numClients)
+    for _, cli := range clients {
+        cli := cli
+        go func() {
+            reader := bufio.NewReader(cli.conn)
+            for i := cli.id; i < b.N; i += numClients {
+                key := fmt.Sprintf("key_%d_%d", cli.id, i%100)
+                if _, err := fmt.Fprintf(cli.conn, "set,%s,value_%d\nget,%s\n", key, i, key); err != nil {
+                    errs <- err
+                    return
+                }
+                if _, err := reader.ReadBytes('\n'); err != nil {
+                    errs <- err
+                    return
+                }
+            }
+            errs <- nil
+        }()
+    }
+    for range clients {
+        if err := <-errs; err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+// TestCloseLeak checks that Close drains the replies buffered for connected
+// clients, closes their connections and stops every goroutine of the server.
+func TestCloseLeak(t *testing.T) {
+    baseline := runtime.NumGoroutine()
+
+    ts := newTestSystem(t)
+    if err := ts.startServer(startServerTries); err != nil {
+        t.Fatalf("Failed to start server: %s\n", err)
+    }
+    clients := newTestClients(3, false)
+    if err := ts.startClients(clients...); err != nil {
+        t.Fatalf("Failed to start clients: %s\n", err)
+    }
+    defer ts.killClients(clients...)
+
+    // leave the replies of a few gets unread by every client
+    const numGets = 10
+    for _, cli := range clients {
+        fmt.Fprintf(cli.conn, "set,key_%d,value\n", cli.id)
+        for i := 0; i < numGets; i++ {
+            fmt.Fprintf(cli.conn, "get,key_%d\n", cli.id)
+        }
+    }
+    time.Sleep(500 * time.Millisecond)
+    ts.server.Close()
+
+    for _, cli := range clients {
+        cli.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+        reader := bufio.NewReader(cli.conn)
+        lines := 0
+        for {
+            if _, err := reader.ReadBytes('\n'); err != nil {
+                if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+                    t.Errorf("client %d: connection was not closed by Close", cli.id)
+                }
+                break
+            }
+            lines++
+        }
+        if lines != numGets*len(clients) {
+            t.Errorf("client %d: read %d replies before the connection was closed, expected %d",
+                cli.id, lines, numGets*len(clients))
+        }
+    }
+
+    deadline := time.Now().Add(2 * time.Second)
+    for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    if n := runtime.NumGoroutine(); n > baseline {
+        buf := make([]byte, 1<<16)
+        t.Errorf("%d goroutines are running after Close, expected at most %d:\n%s",
+            n, baseline, buf[:runtime.Stack(buf, true)])
+    }
+
+    if err := ts.server.Start(0); err == nil {
+        t.Errorf("Start succeeded after Close")
+    }
+    ts.server.Close()
+}
+
+func BenchmarkMapStorage(b *testing.B) {
+    benchmarkStorage(b, NewMapStorage())
+}
+
+func BenchmarkShardedStorage(b *testing.B) {
+    benchmarkStorage(b, NewShardedStorage(defaultShards))
+}
+
+func BenchmarkServerMapStorage(b *testing.B) {
+    benchmarkServer(b, NewMapStorage(), 16)
+}
+
+func BenchmarkServerShardedStorage(b *testing.B) {
+    benchmarkServer(b, NewShardedStorage(defaultShards), 16)
+}
@@ -0,0 +1,100 @@
+package p1
+
+import (
+	"hash/fnv"
+	"sort"
+	"time"
+)
+
+// defaultShards is the number of shards of the storage used by default
+const defaultShards = 32
+
+// shardedStorage stripes keys over several mapStorages by their hash so
+// clients that touch different keys rarely wait for each other
+type shardedStorage struct {
+	shards []*mapStorage
+}
+
+// NewShardedStorage instantiates a storage with the given number of shards
+func NewShardedStorage(shards int) Storage {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &shardedStorage{
+		shards: make([]*mapStorage, shards),
+	}
+	for i := range s.shards {
+		s.shards[i] = newMapStorage()
+	}
+	return s
+}
+
+func (s *shardedStorage) shard(key string) *mapStorage {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *shardedStorage) Get(key string) ([]byte, bool) {
+	return s.shard(key).Get(key)
+}
+
+func (s *shardedStorage) Set(key string, value []byte) {
+	s.shard(key).Set(key, value)
+}
+
+func (s *shardedStorage) SetEx(key string, value []byte, deadline time.Time) {
+	s.shard(key).SetEx(key, value, deadline)
+}
+
+func (s *shardedStorage) Del(key string) bool {
+	return s.shard(key).Del(key)
+}
+
+func (s *shardedStorage) CAS(key string, old, value []byte) bool {
+	return s.shard(key).CAS(key, old, value)
+}
+
+func (s *shardedStorage) Expire(now time.Time) {
+	for _, shard := range s.shards {
+		shard.Expire(now)
+	}
+}
+
+func (s *shardedStorage) Range(fn func(key string, value []byte, deadline time.Time) bool) {
+	for _, shard := range s.shards {
+		more := true
+		shard.Range(func(key string, value []byte, deadline time.Time) bool {
+			more = fn(key, value, deadline)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+// Scan merges the scans of every shard, each shard contributes at most limit keys
+func (s *shardedStorage) Scan(start, end string, limit int, fn func(key string, value []byte) bool) {
+	type pair struct {
+		key   string
+		value []byte
+	}
+	var pairs []pair
+	for _, shard := range s.shards {
+		shard.Scan(start, end, limit, func(key string, value []byte) bool {
+			pairs = append(pairs, pair{key, value})
+			return true
+		})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+	if limit > 0 && len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	for _, p := range pairs {
+		if !fn(p.key, p.value) {
+			return
+		}
+	}
+}
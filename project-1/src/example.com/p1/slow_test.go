@@ -0,0 +1,135 @@
+package p1
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flood makes the server broadcast large get replies to a client that never
+// reads them and returns the server with both clients.
+func flood(t *testing.T, policy SlowClientPolicy) (KeyValueServer, *testClient, *testClient) {
+	server, hostport := startServerWithOptions(t, Options{SlowClient: policy})
+	fast, reader := dial(t, hostport)
+	slow, _ := dial(t, hostport)
+	time.Sleep(100 * time.Millisecond)
+
+	value := bytes.Repeat([]byte("v"), largeMsgSize*4)
+	fmt.Fprintf(fast.conn, "set,key,%s\n", value)
+	for i := 0; i < 2000; i++ {
+		if _, err := fmt.Fprint(fast.conn, "get,key\n"); err != nil {
+			t.Fatal(err)
+		}
+		fast.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := reader.ReadBytes('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	return server, fast, slow
+}
+
+func TestSlowClientDrop(t *testing.T) {
+	for _, policy := range []SlowClientPolicy{DropNewest, DropOldest} {
+		server, fast, slow := flood(t, policy)
+
+		dropped := server.(DropReporter).Dropped()
+		if n := dropped[fast.conn.LocalAddr().String()]; n != 0 {
+			t.Errorf("policy %d: %d messages dropped for the fast client", policy, n)
+		}
+		if n := dropped[slow.conn.LocalAddr().String()]; n == 0 {
+			t.Errorf("policy %d: no messages dropped for the slow client", policy)
+		}
+		if server.Count() != 2 {
+			t.Errorf("policy %d: slow client was disconnected", policy)
+		}
+
+		// the slow client still receives buffered replies
+		slow.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := bufio.NewReader(slow.conn).ReadBytes('\n'); err != nil {
+			t.Errorf("policy %d: %s", policy, err)
+		}
+
+		fast.conn.Close()
+		slow.conn.Close()
+		server.Close()
+	}
+}
+
+func TestSlowClientDisconnect(t *testing.T) {
+	server, fast, slow := flood(t, Disconnect)
+	defer server.Close()
+	defer fast.conn.Close()
+	defer slow.conn.Close()
+
+	if server.Count() != 1 {
+		t.Errorf("Count returned %d after disconnecting the slow client, expected 1", server.Count())
+	}
+	if _, ok := server.(DropReporter).Dropped()[slow.conn.LocalAddr().String()]; ok {
+		t.Errorf("disconnected client is still reported")
+	}
+}
+
+// TestCloseSlowClient checks that Close returns while a client that never
+// reads blocks the writes of the server.
+func TestCloseSlowClient(t *testing.T) {
+	server, fast, slow := flood(t, DropNewest)
+	defer slow.conn.Close()
+	fast.conn.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		server.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(3 * drainTimeout):
+		t.Fatal("Close blocked on a client that does not read")
+	}
+}
+
+// TestSlowClientReplies checks that the replies to a unicast client that reads
+// slowly are never dropped, whatever the slow client policy.
+func TestSlowClientReplies(t *testing.T) {
+	const n = 2000
+	for _, policy := range []SlowClientPolicy{DropNewest, DropOldest, Disconnect} {
+		server, hostport := startServerWithOptions(t, Options{SlowClient: policy})
+		cli, reader := dial(t, hostport)
+
+		value := bytes.Repeat([]byte("v"), largeMsgSize)
+		fmt.Fprintf(cli.conn, "mode,unicast\nset,key,%s\n", value)
+		go func() {
+			for i := 0; i < n; i++ {
+				if _, err := fmt.Fprint(cli.conn, "get,key\n"); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(500 * time.Millisecond)
+
+		cli.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		if line, err := reader.ReadString('\n'); err != nil || line != "mode,unicast,1\n" {
+			t.Fatalf("policy %d: mode returned %q, %v", policy, line, err)
+		}
+		for i := 0; i < n; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("policy %d: reply %d: %s", policy, i, err)
+			}
+			if !strings.HasPrefix(line, "key,") {
+				t.Fatalf("policy %d: reply %d is %.20q", policy, i, line)
+			}
+		}
+		if n := server.(DropReporter).Dropped()[cli.conn.LocalAddr().String()]; n != 0 {
+			t.Errorf("policy %d: %d replies dropped", policy, n)
+		}
+
+		cli.conn.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,36 @@
+package p1
+
+import "time"
+
+// Storage is the storage engine behind a KeyValueServer. Implementations must be
+// safe for concurrent use because each client is served by its own goroutine.
+type Storage interface {
+	// Get fetches the value associated with the key and reports whether the
+	// key is present, keys that have passed their deadline are not present.
+	Get(key string) ([]byte, bool)
+
+	// Set inserts a new key value pair or updates the value for a given key.
+	Set(key string, value []byte)
+
+	// SetEx is like Set but the key disappears at the given deadline.
+	SetEx(key string, value []byte, deadline time.Time)
+
+	// Del removes the key and reports whether it was present.
+	Del(key string) bool
+
+	// CAS replaces the value for a given key with value only if its current
+	// value equals old, an empty old value matches a missing key.
+	CAS(key string, old, value []byte) bool
+
+	// Expire removes every key that has passed its deadline at now.
+	Expire(now time.Time)
+
+	// Range calls fn for every key with its value and deadline (zero for keys
+	// without ttl) until fn returns false.
+	Range(fn func(key string, value []byte, deadline time.Time) bool)
+
+	// Scan calls fn in key order for at most limit keys in [start, end) until
+	// fn returns false. An empty end has no upper bound and a limit that is
+	// not positive has no limit.
+	Scan(start, end string, limit int, fn func(key string, value []byte) bool)
+}
@@ -0,0 +1,271 @@
+// Write-ahead log and snapshots that persist the key value store on disk
+
+package p1
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.db"
+
+	// snapshotInterval is the number of log records after which
+	// the store is compacted into a new snapshot.
+	snapshotInterval = 1000
+)
+
+// log record operations
+const (
+	opSet byte = iota
+	opDel
+	opSetEx // value is prefixed with the deadline, see expiring
+	opBatch // value is the records of a transaction, applied as a whole
+)
+
+// wal appends every update into a log file and periodically replaces
+// the log with a snapshot of the whole store.
+type wal struct {
+	dir     string
+	store   Storage
+	file    *os.File
+	writer  *bufio.Writer
+	entries int
+}
+
+// openWAL loads the store from the snapshot and the log stored
+// in dir and opens the log for appending new records.
+func openWAL(dir string, store Storage) (*wal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if _, err := replay(filepath.Join(dir, snapshotFileName), store); err != nil {
+		return nil, err
+	}
+	entries, err := replay(filepath.Join(dir, walFileName), store)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wal{
+		dir:     dir,
+		store:   store,
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		entries: entries,
+	}, nil
+}
+
+// append writes a record into the log and takes a snapshot when the log grows
+// beyond snapshotInterval records. Updates of the store and their records must
+// be serialized by the caller so the log has them in the same order as the store.
+func (w *wal) append(op byte, key string, value []byte) error {
+	if _, err := w.writer.Write(encodeRecord(op, key, value)); err != nil {
+		return err
+	}
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+
+	w.entries++
+	if w.entries >= snapshotInterval {
+		return w.snapshot()
+	}
+	return nil
+}
+
+// snapshot writes the whole store into a new snapshot file and truncates the log.
+// The snapshot is renamed into place only after it has been synced so a crash
+// leaves either the old or the new snapshot intact.
+func (w *wal) snapshot() error {
+	path := filepath.Join(w.dir, snapshotFileName)
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	w.store.Range(func(key string, value []byte, deadline time.Time) bool {
+		_, err = writer.Write(snapshotRecord(key, value, deadline))
+		return err == nil
+	})
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	// records replayed over the new snapshot are idempotent,
+	// so a crash before this point loses nothing.
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.writer.Reset(w.file)
+	w.entries = 0
+
+	return nil
+}
+
+// close flushes and closes the log file
+func (w *wal) close() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// replay applies every record of the given file to the store and returns the number
+// of applied records. A torn record at the end of the file, left by a crash in
+// the middle of a write, is cut off.
+func replay(path string, store Storage) (int, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var offset int64
+	var entries int
+	reader := bufio.NewReader(file)
+	for {
+		op, key, value, err := readRecord(reader)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return entries, file.Truncate(offset)
+		}
+		if err != nil {
+			return entries, err
+		}
+
+		apply(store, op, key, value)
+		offset += int64(len(encodeRecord(op, key, value)))
+		entries++
+	}
+}
+
+// snapshotRecord serializes a key of the store with its deadline
+func snapshotRecord(key string, value []byte, deadline time.Time) []byte {
+	if deadline.IsZero() {
+		return encodeRecord(opSet, key, value)
+	}
+	return encodeRecord(opSetEx, key, expiring(deadline, value))
+}
+
+// apply performs the operation of a record on the store
+func apply(store Storage, op byte, key string, value []byte) {
+	switch op {
+	case opDel:
+		store.Del(key)
+	case opSetEx:
+		d, v := splitExpiring(value)
+		store.SetEx(key, v, d)
+	case opBatch:
+		reader := bufio.NewReader(bytes.NewReader(value))
+		for {
+			op, key, value, err := readRecord(reader)
+			if err != nil {
+				return
+			}
+			apply(store, op, key, value)
+		}
+	default:
+		store.Set(key, value)
+	}
+}
+
+// encodeRecord serializes an operation as its op byte and two uvarint lengths
+// followed by the key and the value
+func encodeRecord(op byte, key string, value []byte) []byte {
+	buf := make([]byte, 1+2*binary.MaxVarintLen64+len(key)+len(value))
+
+	buf[0] = op
+	n := 1
+	n += binary.PutUvarint(buf[n:], uint64(len(key)))
+	n += binary.PutUvarint(buf[n:], uint64(len(value)))
+	n += copy(buf[n:], key)
+	n += copy(buf[n:], value)
+
+	return buf[:n]
+}
+
+// expiring prefixes the value of a setex record with its deadline
+func expiring(deadline time.Time, value []byte) []byte {
+	buf := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(buf, uint64(deadline.UnixNano()))
+	copy(buf[8:], value)
+	return buf
+}
+
+// splitExpiring extracts the deadline and the value of a setex record
+func splitExpiring(buf []byte) (time.Time, []byte) {
+	if len(buf) < 8 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(buf))), buf[8:]
+}
+
+// readRecord de-serializes an operation written by encodeRecord
+func readRecord(reader *bufio.Reader) (byte, string, []byte, error) {
+	op, err := reader.ReadByte()
+	if err != nil {
+		return 0, "", nil, err
+	}
+	kl, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, "", nil, unexpected(err)
+	}
+	vl, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, "", nil, unexpected(err)
+	}
+
+	key := make([]byte, kl)
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return 0, "", nil, unexpected(err)
+	}
+	value := make([]byte, vl)
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return 0, "", nil, unexpected(err)
+	}
+
+	return op, string(key), value, nil
+}
+
+// unexpected converts EOF in the middle of a record into io.ErrUnexpectedEOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
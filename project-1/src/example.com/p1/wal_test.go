@@ -0,0 +1,169 @@
+package p1
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWALRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	store := NewShardedStorage(defaultShards)
+	w, err := openWAL(dir, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < snapshotInterval+10; i++ {
+		key := fmt.Sprintf("key_%d", i%20)
+		value := []byte(fmt.Sprintf("value,%d\n", i))
+		store.Set(key, value)
+		if err := w.append(opSet, key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = NewShardedStorage(defaultShards)
+	w, err = openWAL(dir, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+
+	if w.entries != 10 {
+		t.Errorf("log has %d records after snapshot, expected 10", w.entries)
+	}
+	for i := snapshotInterval - 10; i < snapshotInterval+10; i++ {
+		key := fmt.Sprintf("key_%d", i%20)
+		if v, _ := store.Get(key); string(v) != fmt.Sprintf("value,%d\n", i) {
+			t.Errorf("%s recovered as %q", key, v)
+		}
+	}
+}
+
+func TestWALTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := openWAL(dir, NewMapStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.append(opSet, "a", []byte("1"))
+	w.append(opSet, "b", []byte("2"))
+	w.append(opSet, "d", []byte("4"))
+	w.append(opDel, "d", nil)
+	w.close()
+
+	// simulate a crash in the middle of writing a record
+	path := filepath.Join(dir, walFileName)
+	record := encodeRecord(opSet, "c", []byte("3"))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(record[:len(record)-1])
+	file.Close()
+
+	store := NewMapStorage()
+	w, err = openWAL(dir, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+
+	a, _ := store.Get("a")
+	b, _ := store.Get("b")
+	_, c := store.Get("c")
+	_, d := store.Get("d")
+	if string(a) != "1" || string(b) != "2" || c || d {
+		t.Errorf("unexpected store after recovery: a=%q b=%q c=%v d=%v", a, b, c, d)
+	}
+	size := 4*len(encodeRecord(opSet, "a", []byte("1"))) - 1
+	if info, _ := os.Stat(path); info.Size() != int64(size) {
+		t.Errorf("torn record was not cut off, log size is %d", info.Size())
+	}
+}
+
+func TestWALExpiring(t *testing.T) {
+	dir := t.TempDir()
+
+	store := NewMapStorage()
+	w, err := openWAL(dir, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetEx("expired", []byte("1"), time.Now().Add(-time.Second))
+	store.SetEx("alive", []byte("2"), time.Now().Add(time.Hour))
+	if err := w.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	w.close()
+
+	store = NewMapStorage()
+	w, err = openWAL(dir, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+
+	if _, ok := store.Get("expired"); ok {
+		t.Errorf("expired key was recovered")
+	}
+	if v, _ := store.Get("alive"); string(v) != "2" {
+		t.Errorf("alive recovered as %q", v)
+	}
+	store.Expire(time.Now())
+	store.Range(func(key string, value []byte, deadline time.Time) bool {
+		if key == "expired" {
+			t.Errorf("expired key was not removed by Expire")
+		}
+		if key == "alive" && deadline.IsZero() {
+			t.Errorf("deadline of alive was lost by the snapshot")
+		}
+		return true
+	})
+}
+
+func TestWALBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := openWAL(dir, NewMapStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.append(opSet, "a", []byte("1"))
+	batch := append(encodeRecord(opSet, "b", []byte("2")), encodeRecord(opDel, "a", nil)...)
+	w.append(opBatch, "", batch)
+	w.close()
+
+	// simulate a crash in the middle of writing a transaction
+	path := filepath.Join(dir, walFileName)
+	batch = append(encodeRecord(opSet, "c", []byte("3")), encodeRecord(opSet, "d", []byte("4"))...)
+	record := encodeRecord(opBatch, "", batch)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(record[:len(record)-1])
+	file.Close()
+
+	store := NewMapStorage()
+	w, err = openWAL(dir, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+
+	_, a := store.Get("a")
+	b, _ := store.Get("b")
+	_, c := store.Get("c")
+	_, d := store.Get("d")
+	if a || string(b) != "2" || c || d {
+		t.Errorf("unexpected store after recovery: a=%v b=%q c=%v d=%v", a, b, c, d)
+	}
+}
@@ -0,0 +1,425 @@
+// Package p1client is a client of the p1 key value server. It speaks the binary
+// protocol of the server in unicast mode, so values may contain any byte and
+// replies are matched with their requests in order.
+package p1client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"../p1"
+)
+
+const (
+	// reconnectTries is the number of dials made before a request fails
+	reconnectTries = 3
+	// reconnectDelay is the delay before the first redial, it doubles after each failure
+	reconnectDelay = 100 * time.Millisecond
+	// defaultTimeout bounds the wait for a reply when Options.Timeout is zero
+	defaultTimeout = 10 * time.Second
+)
+
+var (
+	// ErrClosed is returned by requests on a closed client.
+	ErrClosed = errors.New("[p1client] client is closed")
+	// ErrTimeout is returned by requests whose reply is not received in time.
+	ErrTimeout = errors.New("[p1client] request timed out")
+	// ErrUnauthorized is returned by requests refused by a server that
+	// requires a token.
+	ErrUnauthorized = errors.New("[p1client] not authorized")
+)
+
+// Options configures a Client created by DialWithOptions.
+// The zero value gives the same client as Dial.
+type Options struct {
+	// Timeout bounds the wait for the reply of each request, it is ten
+	// seconds when it is zero. A request that times out drops the connection,
+	// whose later replies could no longer be matched with their requests.
+	Timeout time.Duration
+
+	// Token is sent to the server before any request when it is not empty.
+	Token string
+
+	// TLSConfig is the TLS configuration of the connection,
+	// which is plain TCP when it is nil.
+	TLSConfig *tls.Config
+}
+
+// Update is a change of a watched key pushed by the server.
+type Update struct {
+	Key   string
+	Value []byte
+}
+
+// Pair is a key with its value returned by Scan.
+type Pair struct {
+	Key   string
+	Value []byte
+}
+
+// Client is a connection to a key value server that is safe for concurrent use.
+// Requests of concurrent goroutines are pipelined on the connection without
+// waiting for each other's replies. When the connection is lost the pending
+// requests fail and the next request dials the server again.
+type Client struct {
+	addr string
+	opts Options
+
+	mu      sync.Mutex
+	conn    net.Conn
+	writer  *bufio.Writer
+	pending []*call
+	watches map[string]bool
+	updates chan Update
+	closed  bool
+}
+
+// call is a request that waits for its reply
+type call struct {
+	ch     chan result
+	stream bool       // replied with a frame per item followed by an end frame
+	items  [][][]byte // frames of a stream received so far
+}
+
+type result struct {
+	fields [][]byte
+	items  [][][]byte
+	err    error
+}
+
+// Dial connects to the key value server at the given address.
+func Dial(addr string) (*Client, error) {
+	return DialWithOptions(addr, Options{})
+}
+
+// DialWithOptions connects to the key value server at the given address
+// with the given options.
+func DialWithOptions(addr string, opts Options) (*Client, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultTimeout
+	}
+	c := &Client{
+		addr:    addr,
+		opts:    opts,
+		watches: make(map[string]bool),
+		updates: make(chan Update, 1024),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get fetches the value associated with the key, missing keys have an empty value.
+func (c *Client) Get(key string) ([]byte, error) {
+	fields, err := c.call([]byte("get"), []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return fields[2], nil
+}
+
+// Set inserts a new key value pair or updates the value for a given key.
+// The server does not acknowledge sets, Set returns once the request is sent.
+func (c *Client) Set(key string, value []byte) error {
+	_, err := c.send(nil, []byte("set"), []byte(key), value)
+	return err
+}
+
+// SetEx is like Set but the key disappears after the given ttl.
+func (c *Client) SetEx(key string, value []byte, ttl time.Duration) error {
+	ms := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	_, err := c.send(nil, []byte("setex"), []byte(key), []byte(ms), value)
+	return err
+}
+
+// Del removes the key and reports whether it was present.
+func (c *Client) Del(key string) (bool, error) {
+	return c.boolean([]byte("del"), []byte(key))
+}
+
+// Exists reports whether the key is present.
+func (c *Client) Exists(key string) (bool, error) {
+	return c.boolean([]byte("exists"), []byte(key))
+}
+
+// CAS replaces the value for a given key with value only if its current value
+// equals old and reports whether it was replaced.
+func (c *Client) CAS(key string, old, value []byte) (bool, error) {
+	return c.boolean([]byte("cas"), []byte(key), old, value)
+}
+
+// Scan returns in key order at most limit pairs whose keys are in [start, end).
+// An empty end has no upper bound and a limit that is not positive has no limit.
+func (c *Client) Scan(start, end string, limit int) ([]Pair, error) {
+	ch, err := c.send(&call{stream: true},
+		[]byte("scan"), []byte(start), []byte(end), []byte(strconv.Itoa(limit)))
+	if err != nil {
+		return nil, err
+	}
+	r := c.wait(ch)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.fields) != 0 && string(r.fields[0]) == "auth" {
+		return nil, ErrUnauthorized
+	}
+
+	pairs := make([]Pair, 0, len(r.items))
+	for _, fields := range r.items {
+		if len(fields) != 3 {
+			return nil, errors.New("[p1client] malformed reply")
+		}
+		pairs = append(pairs, Pair{Key: string(fields[1]), Value: fields[2]})
+	}
+	return pairs, nil
+}
+
+// Watch subscribes to the changes of the key, or of every key with the given
+// prefix when it ends with '*'. Changes are delivered on Updates and watches
+// are restored after a reconnection.
+func (c *Client) Watch(key string) error {
+	if _, err := c.call([]byte("watch"), []byte(key)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.watches[key] = true
+	return nil
+}
+
+// Unwatch cancels a subscription made by Watch.
+func (c *Client) Unwatch(key string) error {
+	c.mu.Lock()
+	delete(c.watches, key)
+	c.mu.Unlock()
+
+	_, err := c.call([]byte("unwatch"), []byte(key))
+	return err
+}
+
+// Updates returns the channel of changes to watched keys. Updates are dropped
+// when the channel is full and the channel is closed by Close.
+func (c *Client) Updates() <-chan Update {
+	return c.updates
+}
+
+// Close closes the connection and fails the pending requests.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	c.drop(ErrClosed)
+	close(c.updates)
+
+	return nil
+}
+
+// boolean sends a request that is replied with 1 or 0
+func (c *Client) boolean(fields ...[]byte) (bool, error) {
+	reply, err := c.call(fields...)
+	if err != nil {
+		return false, err
+	}
+	return string(reply[2]) == "1", nil
+}
+
+// call sends a request and waits for its reply
+func (c *Client) call(fields ...[]byte) ([][]byte, error) {
+	ch, err := c.send(&call{}, fields...)
+	if err != nil {
+		return nil, err
+	}
+	r := c.wait(ch)
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.fields) != 3 {
+		return nil, errors.New("[p1client] malformed reply")
+	}
+	if string(r.fields[0]) == "auth" && string(fields[0]) != "auth" {
+		return nil, ErrUnauthorized
+	}
+	return r.fields, nil
+}
+
+// wait returns the reply received on the channel of a request. When it is not
+// received in time the connection is dropped, which fails the request.
+func (c *Client) wait(ch chan result) result {
+	timer := time.NewTimer(c.opts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-ch:
+		return r
+	case <-timer.C:
+	}
+
+	c.mu.Lock()
+	select {
+	case r := <-ch:
+		c.mu.Unlock()
+		return r
+	default:
+	}
+	c.drop(ErrTimeout)
+	c.mu.Unlock()
+	return <-ch
+}
+
+// send writes a request, (re)connecting if needed, and returns the channel of
+// its reply. Requests without a reply have no call.
+func (c *Client) send(reply *call, fields ...[]byte) (chan result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return c.write(reply, fields...)
+}
+
+// write must be called with mu held on a connected client
+func (c *Client) write(reply *call, fields ...[]byte) (chan result, error) {
+	var ch chan result
+	if reply != nil {
+		ch = make(chan result, 1)
+		reply.ch = ch
+		c.pending = append(c.pending, reply)
+	}
+
+	err := writeFrame(c.writer, fields...)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil {
+		c.drop(err)
+		return nil, err
+	}
+	return ch, nil
+}
+
+// connect dials the server, authenticates, switches to unicast mode and
+// restores the watches. It must be called with mu held.
+func (c *Client) connect() error {
+	var conn net.Conn
+	var err error
+	delay := reconnectDelay
+	for i := 0; i < reconnectTries; i++ {
+		if c.opts.TLSConfig != nil {
+			conn, err = tls.Dial("tcp", c.addr, c.opts.TLSConfig)
+		} else {
+			conn, err = net.Dial("tcp", c.addr)
+		}
+		if err == nil {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	c.writer = bufio.NewWriter(conn)
+	c.writer.WriteByte(p1.BinaryHandshake)
+	go c.read(conn, bufio.NewReader(conn))
+
+	if c.opts.Token != "" {
+		if _, err := c.write(&call{}, []byte("auth"), []byte(c.opts.Token)); err != nil {
+			return err
+		}
+	}
+	if _, err := c.write(&call{}, []byte("mode"), []byte("unicast")); err != nil {
+		return err
+	}
+	for key := range c.watches {
+		if _, err := c.write(&call{}, []byte("watch"), []byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drop closes the connection and fails its pending requests. It must be called with mu held.
+func (c *Client) drop(err error) {
+	if c.conn == nil {
+		return
+	}
+	c.conn.Close()
+	c.conn = nil
+	for _, p := range c.pending {
+		p.ch <- result{err: err}
+	}
+	c.pending = nil
+}
+
+// read delivers the frames of the given connection until it is lost. Frames
+// before the reply of the mode request are broadcasts of other clients' gets,
+// but for the replies of auth requests. A server that requires a token refuses
+// the requests of an unauthenticated client with an auth reply.
+func (c *Client) read(conn net.Conn, reader *bufio.Reader) {
+	unicast := false
+	for {
+		fields, err := readFrame(reader)
+
+		c.mu.Lock()
+		if c.conn != conn {
+			c.mu.Unlock()
+			return
+		}
+		if err != nil {
+			c.drop(err)
+			c.mu.Unlock()
+			return
+		}
+		command := ""
+		if len(fields) != 0 {
+			command = string(fields[0])
+		}
+		if !unicast && command != "mode" && command != "auth" {
+			c.mu.Unlock()
+			continue
+		}
+		if command == "mode" {
+			unicast = true
+		}
+
+		if len(fields) == 3 && command == "notify" {
+			select {
+			case c.updates <- Update{Key: string(fields[1]), Value: fields[2]}:
+			default:
+			}
+		} else if len(c.pending) != 0 {
+			p := c.pending[0]
+			if p.stream && command != "end" && command != "auth" {
+				p.items = append(p.items, fields)
+			} else {
+				p.ch <- result{fields: fields, items: p.items}
+				c.pending = c.pending[1:]
+			}
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,226 @@
+package p1client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"../p1"
+)
+
+func startServer(t *testing.T) (p1.KeyValueServer, string) {
+	return startServerWithOptions(t, p1.Options{})
+}
+
+func startServerWithOptions(t *testing.T, opts p1.Options) (p1.KeyValueServer, string) {
+	randGen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < 5; i++ {
+		server := p1.NewWithOptions(opts)
+		port := 2000 + randGen.Intn(10000)
+		if err := server.Start(port); err == nil {
+			return server, net.JoinHostPort("localhost", strconv.Itoa(port))
+		}
+	}
+	t.Fatal("failed to start server")
+	return nil, ""
+}
+
+func TestClient(t *testing.T) {
+	server, addr := startServer(t)
+	defer server.Close()
+
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	value := []byte("a,b\nc")
+	if err := c.Set("k", value); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get("k"); err != nil || string(v) != string(value) {
+		t.Errorf("Get returned %q, %v", v, err)
+	}
+	if ok, err := c.CAS("k", value, []byte("2")); err != nil || !ok {
+		t.Errorf("CAS returned %v, %v", ok, err)
+	}
+	if ok, err := c.Exists("k"); err != nil || !ok {
+		t.Errorf("Exists returned %v, %v", ok, err)
+	}
+	if ok, err := c.Del("k"); err != nil || !ok {
+		t.Errorf("Del returned %v, %v", ok, err)
+	}
+	if ok, err := c.Exists("k"); err != nil || ok {
+		t.Errorf("Exists returned %v, %v after Del", ok, err)
+	}
+	for _, key := range []string{"c", "a", "b", "d"} {
+		if err := c.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pairs, err := c.Scan("a", "d", 2)
+	if err != nil || len(pairs) != 2 || pairs[0].Key != "a" || pairs[1].Key != "b" ||
+		string(pairs[1].Value) != "b" {
+		t.Errorf("Scan returned %v, %v", pairs, err)
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	server, addr := startServer(t)
+	defer server.Close()
+
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("key_%d", i)
+				value := fmt.Sprintf("value_%d_%d", i, j)
+				if err := c.Set(key, []byte(value)); err != nil {
+					t.Error(err)
+					return
+				}
+				if v, err := c.Get(key); err != nil || string(v) != value {
+					t.Errorf("Get(%s) returned %q, %v, expected %s", key, v, err, value)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWatchAndReconnect(t *testing.T) {
+	server, addr := startServer(t)
+	defer server.Close()
+
+	watcher, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	writer, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	if err := watcher.Watch("user:*"); err != nil {
+		t.Fatal(err)
+	}
+
+	// break the connection of the watcher, the next request reconnects
+	watcher.mu.Lock()
+	watcher.conn.Close()
+	watcher.mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := watcher.Get("user:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	writer.Set("user:1", []byte("alice"))
+	select {
+	case u := <-watcher.Updates():
+		if u.Key != "user:1" || string(u.Value) != "alice" {
+			t.Errorf("unexpected update %s=%q", u.Key, u.Value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("update was not delivered after reconnection")
+	}
+}
+
+// TestTimeout connects to a server that never replies
+func TestToken(t *testing.T) {
+	server, addr := startServerWithOptions(t, p1.Options{Token: "secret"})
+	defer server.Close()
+
+	c, err := DialWithOptions(addr, Options{Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Set("k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get("k"); err != nil || string(v) != "v" {
+		t.Errorf("Get returned %q, %v", v, err)
+	}
+	if pairs, err := c.Scan("", "", 0); err != nil || len(pairs) != 1 {
+		t.Errorf("Scan returned %v, %v", pairs, err)
+	}
+
+	for _, token := range []string{"", "guess"} {
+		c, err := DialWithOptions(addr, Options{Token: token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Get("k"); err != ErrUnauthorized {
+			t.Errorf("token %q: Get returned %v", token, err)
+		}
+		if _, err := c.Scan("", "", 0); err != ErrUnauthorized {
+			t.Errorf("token %q: Scan returned %v", token, err)
+		}
+		c.Close()
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	c, err := DialWithOptions(ln.Addr().String(), Options{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Get("k"); err != ErrTimeout {
+		t.Errorf("Get returned %v, expected ErrTimeout", err)
+	}
+	if _, err := c.Scan("", "", 0); err != ErrTimeout {
+		t.Errorf("Scan returned %v, expected ErrTimeout", err)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	var buf bytes.Buffer
+	writer := bufio.NewWriter(&buf)
+	writeFrame(writer, []byte("get"), []byte("k"), []byte("v"))
+	writer.Flush()
+	if fields, err := readFrame(bufio.NewReader(&buf)); err != nil || len(fields) != 3 || string(fields[2]) != "v" {
+		t.Errorf("readFrame returned %q, %v", fields, err)
+	}
+
+	// a field claiming more than the largest size is not allocated
+	frame := binary.AppendUvarint([]byte{1}, p1.MaxFieldSize+1)
+	if _, err := readFrame(bufio.NewReader(bytes.NewReader(frame))); err == nil {
+		t.Error("readFrame accepted a field that is too large")
+	}
+}
@@ -0,0 +1,60 @@
+package p1client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"../p1"
+)
+
+// readFrame reads the fields of a frame, a uvarint number of fields
+// followed by every field as a uvarint length and its bytes
+func readFrame(reader *bufio.Reader) ([][]byte, error) {
+	n, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if n > 4 {
+		return nil, errors.New("[p1client] too many fields")
+	}
+
+	fields := make([][]byte, n)
+	for i := range fields {
+		l, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		if l > p1.MaxFieldSize {
+			return nil, errors.New("[p1client] field is too large")
+		}
+		fields[i] = make([]byte, l)
+		if _, err := io.ReadFull(reader, fields[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return fields, nil
+}
+
+// writeFrame writes the given fields as a frame
+func writeFrame(writer *bufio.Writer, fields ...[]byte) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	n := binary.PutUvarint(buf, uint64(len(fields)))
+	if _, err := writer.Write(buf[:n]); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		n := binary.PutUvarint(buf, uint64(len(field)))
+		if _, err := writer.Write(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := writer.Write(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
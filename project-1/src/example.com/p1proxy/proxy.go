@@ -0,0 +1,338 @@
+// Package p1proxy is a routing proxy that spreads the keys of the p1 text
+// protocol over several p1 servers with a consistent hash ring. Replies are
+// sent only to the requesting client, as by a p1 server in unicast mode.
+package p1proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"../p1client"
+)
+
+// migrateBatch is the number of pairs fetched at once while moving keys
+const migrateBatch = 1024
+
+// Proxy routes the requests of its clients to the backend that owns each key.
+type Proxy struct {
+	ln net.Listener
+
+	// mu guards the ring and the backends, routing read locks it and
+	// migrations write lock it so no request sees a key being moved
+	mu       sync.RWMutex
+	ring     *ring
+	backends map[string]*p1client.Client
+	opts     p1client.Options
+
+	cmu     sync.Mutex
+	clients map[net.Conn]bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// New connects to the given backends and returns a proxy that is not started.
+func New(backends ...string) (*Proxy, error) {
+	return NewWithOptions(p1client.Options{}, backends...)
+}
+
+// NewWithOptions is like New but connects to the backends, including those
+// added later, with the given client options, e.g. the token and the TLS
+// configuration of protected backends.
+func NewWithOptions(opts p1client.Options, backends ...string) (*Proxy, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("[p1proxy] no backends")
+	}
+	p := &Proxy{
+		ring:     newRing(),
+		backends: make(map[string]*p1client.Client),
+		opts:     opts,
+		clients:  make(map[net.Conn]bool),
+	}
+	for _, addr := range backends {
+		if err := p.AddBackend(addr); err != nil {
+			p.closeBackends()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Start listens for clients on the given port.
+func (p *Proxy) Start(port int) error {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+
+	if p.closed {
+		return errors.New("[p1proxy] proxy is closed")
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	p.ln = ln
+	p.wg.Add(1)
+	go p.listen()
+	return nil
+}
+
+// Count returns the number of connected clients.
+func (p *Proxy) Count() int {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+
+	return len(p.clients)
+}
+
+// Close disconnects every client and backend and waits for the goroutines of the proxy.
+func (p *Proxy) Close() {
+	p.cmu.Lock()
+	if p.closed {
+		p.cmu.Unlock()
+		return
+	}
+	p.closed = true
+	if p.ln != nil {
+		p.ln.Close()
+	}
+	for conn := range p.clients {
+		conn.Close()
+	}
+	p.cmu.Unlock()
+
+	p.wg.Wait()
+	p.closeBackends()
+}
+
+// Backends returns the addresses of the backends.
+func (p *Proxy) Backends() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	backends := make([]string, 0, len(p.backends))
+	for addr := range p.backends {
+		backends = append(backends, addr)
+	}
+	return backends
+}
+
+// AddBackend connects to a new backend and moves to it the keys that it owns
+// on the ring. Keys are moved without their time to live.
+func (p *Proxy) AddBackend(addr string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.backends[addr]; ok {
+		return fmt.Errorf("[p1proxy] backend %s already exists", addr)
+	}
+	c, err := p1client.DialWithOptions(addr, p.opts)
+	if err != nil {
+		return err
+	}
+	p.backends[addr] = c
+	p.ring.add(addr)
+
+	for from, old := range p.backends {
+		if from == addr {
+			continue
+		}
+		if err := p.migrate(old, func(key string) bool { return p.ring.owner(key) == addr }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveBackend moves the keys of the backend to the remaining backends and
+// disconnects from it. Keys are moved without their time to live.
+func (p *Proxy) RemoveBackend(addr string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, ok := p.backends[addr]
+	if !ok {
+		return fmt.Errorf("[p1proxy] backend %s does not exist", addr)
+	}
+	if len(p.backends) == 1 {
+		return errors.New("[p1proxy] cannot remove the last backend")
+	}
+	p.ring.remove(addr)
+
+	if err := p.migrate(c, func(string) bool { return true }); err != nil {
+		// keep routing to the backend that still has keys
+		p.ring.add(addr)
+		return err
+	}
+	delete(p.backends, addr)
+	c.Close()
+	return nil
+}
+
+// migrate moves the keys of the given backend that match move to their
+// owners on the ring. It must be called with mu write locked.
+func (p *Proxy) migrate(from *p1client.Client, move func(key string) bool) error {
+	start := ""
+	for {
+		pairs, err := from.Scan(start, "", migrateBatch)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			if !move(pair.Key) {
+				continue
+			}
+			// unlike Set, CAS is acknowledged, so the key is deleted
+			// only once its new owner has stored it
+			ok, err := p.backends[p.ring.owner(pair.Key)].CAS(pair.Key, nil, pair.Value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("[p1proxy] %s is already stored by its new backend", pair.Key)
+			}
+			if _, err := from.Del(pair.Key); err != nil {
+				return err
+			}
+		}
+		if len(pairs) < migrateBatch {
+			return nil
+		}
+		// the smallest key after the last one
+		start = pairs[len(pairs)-1].Key + "\x00"
+	}
+}
+
+func (p *Proxy) closeBackends() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.backends {
+		c.Close()
+	}
+	p.backends = make(map[string]*p1client.Client)
+	p.ring = newRing()
+}
+
+func (p *Proxy) listen() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		p.cmu.Lock()
+		if p.closed {
+			p.cmu.Unlock()
+			conn.Close()
+			return
+		}
+		p.clients[conn] = true
+		p.wg.Add(1)
+		p.cmu.Unlock()
+
+		go p.serve(conn)
+	}
+}
+
+// serve answers the requests of a client in order until it disconnects
+func (p *Proxy) serve(conn net.Conn) {
+	defer p.wg.Done()
+	defer func() {
+		p.cmu.Lock()
+		delete(p.clients, conn)
+		p.cmu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		reply, err := p.handle(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			// the backend is lost, the client learns it from the closed connection
+			return
+		}
+		if reply == "" {
+			continue
+		}
+		writer.WriteString(reply)
+		// flush only when no other request is waiting
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle routes a request line to the owner of its key and returns the reply
+// line, which is empty for sets and unknown commands
+func (p *Proxy) handle(line string) (string, error) {
+	fields := strings.SplitN(line, ",", 2)
+	if len(fields) != 2 {
+		return "", nil
+	}
+	command, args := fields[0], fields[1]
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	switch command {
+	case "get":
+		value, err := p.backend(args).Get(args)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s,%s\n", args, value), nil
+	case "set":
+		kv := strings.SplitN(args, ",", 2)
+		if len(kv) != 2 {
+			return "", nil
+		}
+		return "", p.backend(kv[0]).Set(kv[0], []byte(kv[1]))
+	case "del", "exists":
+		var ok bool
+		var err error
+		if command == "del" {
+			ok, err = p.backend(args).Del(args)
+		} else {
+			ok, err = p.backend(args).Exists(args)
+		}
+		if err != nil {
+			return "", err
+		}
+		return boolean(command, args, ok), nil
+	case "cas":
+		kv := strings.SplitN(args, ",", 3)
+		if len(kv) != 3 {
+			return "", nil
+		}
+		ok, err := p.backend(kv[0]).CAS(kv[0], []byte(kv[1]), []byte(kv[2]))
+		if err != nil {
+			return "", err
+		}
+		return boolean(command, kv[0], ok), nil
+	}
+	return "", nil
+}
+
+// backend returns the client of the owner of the key, it must be called with mu held
+func (p *Proxy) backend(key string) *p1client.Client {
+	return p.backends[p.ring.owner(key)]
+}
+
+func boolean(command, key string, ok bool) string {
+	if ok {
+		return fmt.Sprintf("%s,%s,1\n", command, key)
+	}
+	return fmt.Sprintf("%s,%s,0\n", command, key)
+}
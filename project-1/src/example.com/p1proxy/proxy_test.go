@@ -0,0 +1,253 @@
+package p1proxy
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"../p1"
+	"../p1client"
+)
+
+func startServer(t *testing.T) (p1.KeyValueServer, string) {
+	return startServerWithOptions(t, p1.Options{})
+}
+
+func startServerWithOptions(t *testing.T, opts p1.Options) (p1.KeyValueServer, string) {
+	randGen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < 5; i++ {
+		server := p1.NewWithOptions(opts)
+		port := 2000 + randGen.Intn(10000)
+		if err := server.Start(port); err == nil {
+			return server, net.JoinHostPort("localhost", strconv.Itoa(port))
+		}
+	}
+	t.Fatal("failed to start server")
+	return nil, ""
+}
+
+func startProxy(t *testing.T, backends ...string) (*Proxy, string) {
+	return startProxyWithOptions(t, p1client.Options{}, backends...)
+}
+
+func startProxyWithOptions(t *testing.T, opts p1client.Options, backends ...string) (*Proxy, string) {
+	p, err := NewWithOptions(opts, backends...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	randGen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < 5; i++ {
+		port := 2000 + randGen.Intn(10000)
+		if err := p.Start(port); err == nil {
+			return p, net.JoinHostPort("localhost", strconv.Itoa(port))
+		}
+	}
+	t.Fatal("failed to start proxy")
+	return nil, ""
+}
+
+// request writes the line and reads its reply unless expected is empty
+func request(t *testing.T, conn net.Conn, reader *bufio.Reader, line, expected string) {
+	if _, err := fmt.Fprint(conn, line); err != nil {
+		t.Fatal(err)
+	}
+	if expected == "" {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("%q: %s", line, err)
+	}
+	if reply != expected {
+		t.Fatalf("%q: got %q, expected %q", line, reply, expected)
+	}
+}
+
+// count returns the number of keys stored by the server at addr
+func count(t *testing.T, addr string) int {
+	c, err := p1client.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	pairs, err := c.Scan("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(pairs)
+}
+
+func TestProxy(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		server, addr := startServer(t)
+		defer server.Close()
+		addrs = append(addrs, addr)
+	}
+
+	proxy, hostport := startProxy(t, addrs[0], addrs[1])
+	defer proxy.Close()
+	conn, err := net.Dial("tcp", hostport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	const numKeys = 300
+	for i := 0; i < numKeys; i++ {
+		request(t, conn, reader, fmt.Sprintf("set,key_%d,value_%d\n", i, i), "")
+	}
+	request(t, conn, reader, "cas,key_0,value_0,v0\n", "cas,key_0,1\n")
+	request(t, conn, reader, "del,key_1\n", "del,key_1,1\n")
+	request(t, conn, reader, "exists,key_1\n", "exists,key_1,0\n")
+	request(t, conn, reader, "get,key_0\n", "key_0,v0\n")
+
+	// the replies of gets follow every set on the same backend
+	check := func() {
+		for i := 2; i < numKeys; i++ {
+			request(t, conn, reader, fmt.Sprintf("get,key_%d\n", i), fmt.Sprintf("key_%d,value_%d\n", i, i))
+		}
+	}
+	check()
+	if n, m := count(t, addrs[0]), count(t, addrs[1]); n == 0 || m == 0 || n+m != numKeys-1 {
+		t.Fatalf("backends store %d and %d keys, expected %d in total", n, m, numKeys-1)
+	}
+
+	if err := proxy.AddBackend(addrs[2]); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	n, m, k := count(t, addrs[0]), count(t, addrs[1]), count(t, addrs[2])
+	if k == 0 || n+m+k != numKeys-1 {
+		t.Fatalf("backends store %d, %d and %d keys after adding one", n, m, k)
+	}
+
+	if err := proxy.RemoveBackend(addrs[0]); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if n := count(t, addrs[0]); n != 0 {
+		t.Fatalf("removed backend still stores %d keys", n)
+	}
+	if err := proxy.RemoveBackend(addrs[0]); err == nil {
+		t.Fatal("removed a backend twice")
+	}
+}
+
+func TestProxyToken(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		server, addr := startServerWithOptions(t, p1.Options{Token: "secret"})
+		defer server.Close()
+		addrs = append(addrs, addr)
+	}
+
+	proxy, hostport := startProxyWithOptions(t, p1client.Options{Token: "secret"}, addrs...)
+	defer proxy.Close()
+	conn, err := net.Dial("tcp", hostport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for i := 0; i < 10; i++ {
+		request(t, conn, reader, fmt.Sprintf("set,key_%d,value_%d\n", i, i), "")
+	}
+	for i := 0; i < 10; i++ {
+		request(t, conn, reader, fmt.Sprintf("get,key_%d\n", i), fmt.Sprintf("key_%d,value_%d\n", i, i))
+	}
+}
+
+// TestProxyMigrateLarge moves more keys than a migration fetches at once.
+func TestProxyMigrateLarge(t *testing.T) {
+	const numKeys = 3*migrateBatch + 10
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		server, addr := startServer(t)
+		defer server.Close()
+		addrs = append(addrs, addr)
+	}
+
+	c, err := p1client.Dial(addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < numKeys; i++ {
+		if err := c.Set(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sets are not acknowledged, a scan on the same connection follows them
+	if pairs, err := c.Scan("", "", 0); err != nil || len(pairs) != numKeys {
+		t.Fatalf("backend stores %d keys, %v", len(pairs), err)
+	}
+
+	proxy, _ := startProxy(t, addrs[0])
+	defer proxy.Close()
+	done := make(chan error, 1)
+	go func() {
+		if err := proxy.AddBackend(addrs[1]); err != nil {
+			done <- err
+			return
+		}
+		done <- proxy.RemoveBackend(addrs[0])
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("migration did not end")
+	}
+
+	if n, m := count(t, addrs[0]), count(t, addrs[1]); n != 0 || m != numKeys {
+		t.Fatalf("backends store %d and %d keys after migration, expected 0 and %d", n, m, numKeys)
+	}
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		if v, err := proxy.backend(key).Get(key); err != nil || string(v) != fmt.Sprintf("value_%d", i) {
+			t.Fatalf("%s migrated as %q, %v", key, v, err)
+		}
+	}
+}
+
+// TestProxyMigrateConflict checks that a key is kept by its backend when its
+// new owner already stores it.
+func TestProxyMigrateConflict(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		server, addr := startServer(t)
+		defer server.Close()
+		addrs = append(addrs, addr)
+	}
+	proxy, _ := startProxy(t, addrs...)
+	defer proxy.Close()
+
+	key := "key_0"
+	for i := 1; proxy.ring.owner(key) != addrs[0]; i++ {
+		key = fmt.Sprintf("key_%d", i)
+	}
+	if _, err := proxy.backends[addrs[0]].CAS(key, nil, []byte("fresh")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proxy.backends[addrs[1]].CAS(key, nil, []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := proxy.RemoveBackend(addrs[0]); err == nil {
+		t.Fatal("removed a backend whose key is stored by another backend")
+	}
+	if v, err := proxy.backends[addrs[0]].Get(key); err != nil || string(v) != "fresh" {
+		t.Errorf("%s kept as %q, %v", key, v, err)
+	}
+}
@@ -0,0 +1,72 @@
+package p1proxy
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// replicas is the number of points of each backend on the ring, more points
+// spread the keys more evenly over the backends
+const replicas = 64
+
+// ring is a consistent hash ring of backend addresses. Adding or removing a
+// backend only moves the keys between that backend and its neighbours.
+// It is not safe for concurrent use.
+type ring struct {
+	points []uint32          // sorted hashes of the points
+	owners map[uint32]string // backend of each point
+}
+
+func newRing() *ring {
+	return &ring{
+		owners: make(map[uint32]string),
+	}
+}
+
+func hash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// add places the points of the backend on the ring
+func (r *ring) add(backend string) {
+	for i := 0; i < replicas; i++ {
+		point := hash(strconv.Itoa(i) + "-" + backend)
+		if _, ok := r.owners[point]; ok {
+			// the rare collision keeps its first owner
+			continue
+		}
+		r.owners[point] = backend
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// remove takes the points of the backend off the ring
+func (r *ring) remove(backend string) {
+	points := r.points[:0]
+	for _, point := range r.points {
+		if r.owners[point] == backend {
+			delete(r.owners, point)
+		} else {
+			points = append(points, point)
+		}
+	}
+	r.points = points
+}
+
+// owner returns the backend of the first point after the hash of the key,
+// or "" when the ring is empty
+func (r *ring) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
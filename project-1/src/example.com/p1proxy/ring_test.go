@@ -0,0 +1,44 @@
+package p1proxy
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing()
+	r.add("a")
+	r.add("b")
+	r.add("c")
+
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		owners[key] = r.owner(key)
+		counts[owners[key]]++
+	}
+	for _, backend := range []string{"a", "b", "c"} {
+		if counts[backend] < 500 {
+			t.Errorf("backend %s owns only %d of 3000 keys", backend, counts[backend])
+		}
+	}
+
+	// only the keys of a removed backend move
+	r.remove("b")
+	for key, owner := range owners {
+		if o := r.owner(key); owner != "b" && o != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, o)
+		} else if o == "b" {
+			t.Fatalf("key %s is owned by a removed backend", key)
+		}
+	}
+
+	// only keys of the added backend move
+	r.add("b")
+	for key, owner := range owners {
+		if o := r.owner(key); o != owner {
+			t.Fatalf("key %s moved from %s to %s", key, owner, o)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "strings"
+    "example.com/p1client"
+    "example.com/p1proxy"
+)
+
+const defaultPort = 9998
+
+func main() {
+    port := flag.Int("port", defaultPort, "port of the proxy")
+    backends := flag.String("backends", "", "comma separated addresses of the backend servers")
+    token := flag.String("token", "", "secret sent to the backend servers with the auth command")
+    flag.Parse()
+
+    // Connect to the backends.
+    proxy, err := p1proxy.NewWithOptions(p1client.Options{Token: *token}, strings.Split(*backends, ",")...)
+    if err != nil {
+        fmt.Printf("Proxy could not connect to the backends: %s\n", err)
+        return
+    }
+
+    // Start the proxy and continue listening for client connections in the background.
+    if err := proxy.Start(*port); err != nil {
+        fmt.Printf("Proxy could not be started: %s\n", err)
+        return
+    }
+
+    fmt.Printf("Started proxy on port %d for %s...\n", *port, *backends)
+
+    // Block forever.
+    select {}
+}
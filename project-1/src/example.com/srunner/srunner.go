@@ -1,6 +1,7 @@
 package main
 
 import (
+    "flag"
     "fmt"
     "example.com/p1"
 )
@@ -8,8 +9,25 @@ import (
 const defaultPort = 9999
 
 func main() {
+    dir := flag.String("dir", "", "data directory for the persistent database")
+    unicast := flag.Bool("unicast", false, "send get replies only to the requesting client")
+    primary := flag.String("primary", "", "address of the primary when started as a backup")
+    cert := flag.String("cert", "", "certificate file for TLS connections")
+    key := flag.String("key", "", "private key file for TLS connections")
+    token := flag.String("token", "", "secret that clients must send with the auth command")
+    admin := flag.String("admin", "", "address of the HTTP listener serving metrics")
+    flag.Parse()
+
     // Initialize the server.
-    server := p1.New()
+    server := p1.NewWithOptions(p1.Options{
+        Dir:       *dir,
+        Unicast:   *unicast,
+        Primary:   *primary,
+        CertFile:  *cert,
+        KeyFile:   *key,
+        Token:     *token,
+        AdminAddr: *admin,
+    })
     if server == nil {
         fmt.Println("New() returned a nil server. Exiting...")
         return
@@ -0,0 +1,37 @@
+package lsp
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// checksum returns the CRC-32 of the connection ID, sequence number, size,
+// fragment flag and the first size bytes of the payload of a data message.
+func checksum(connID, seqNum, size int, more bool, payload []byte) uint32 {
+	if size < 0 || size > len(payload) {
+		size = len(payload)
+	}
+	var header [3*binary.MaxVarintLen64 + 1]byte
+	n := binary.PutVarint(header[:], int64(connID))
+	n += binary.PutVarint(header[n:], int64(seqNum))
+	n += binary.PutVarint(header[n:], int64(size))
+	if more {
+		header[n] = 1
+	}
+	n++
+	return crc32.Update(crc32.ChecksumIEEE(header[:n]), crc32.IEEETable, payload[:size])
+}
+
+// valid reports whether a data message received in the given encoding is
+// complete and intact, the payload may be longer than its size. JSON peers
+// that predate checksums send none, so their messages are only checked for
+// their size.
+func (m *Message) valid(encoding Encoding) bool {
+	if m.Size < 0 || len(m.Payload) < m.Size {
+		return false
+	}
+	if encoding == EncodingJSON && m.Checksum == 0 {
+		return true
+	}
+	return m.Checksum == checksum(m.ConnID, m.SeqNum, m.Size, m.More, m.Payload)
+}
@@ -14,14 +14,19 @@ import (
 type client struct {
 	id      int
 	udpConn *net.UDPConn
+	enc     Encoding // offered while connecting, then the one of the server
 
-	incoming chan *Message
+	incoming chan *addressableMessage
 
-	tbuffer map[int]*Message
+	tbuffer map[int]*pending
+	rto     *rtoEstimator
 	tmsg    chan *Message
 	tsq     int
 
-	rbuffer map[int][]byte
+	fragments [][]byte // fragments of the payload being sent
+
+	rbuffer map[int]*Message
+	partial reassembler
 	rmsg    chan []byte
 	rsq     int
 
@@ -43,6 +48,11 @@ type client struct {
 // hostport is a colon-separated string identifying the server's host address
 // and port number (i.e., "localhost:9999").
 func NewClient(hostport string, params *Params) (Client, error) {
+	return newClient(hostport, params, EncodingBinary)
+}
+
+// newClient is NewClient offering the given encoding in its first connect message
+func newClient(hostport string, params *Params, enc Encoding) (Client, error) {
 	addr, err := net.ResolveUDPAddr("udp", hostport)
 	if err != nil {
 		log.Fatal(err)
@@ -57,14 +67,16 @@ func NewClient(hostport string, params *Params) (Client, error) {
 	cli := &client{
 		id:      -1,
 		udpConn: conn,
+		enc:     enc,
 
-		incoming: make(chan *Message, 1024),
+		incoming: make(chan *addressableMessage, 1024),
 
-		tbuffer: make(map[int]*Message),
+		tbuffer: make(map[int]*pending),
+		rto:     newRTOEstimator(params),
 		tmsg:    make(chan *Message, 1000),
 		tsq:     0,
 
-		rbuffer: make(map[int][]byte),
+		rbuffer: make(map[int]*Message),
 		rmsg:    make(chan []byte, 1000),
 		rsq:     1,
 
@@ -80,9 +92,9 @@ func NewClient(hostport string, params *Params) (Client, error) {
 	// send connect message
 	// new connect message
 	connectMessage := NewConnect()
-	cli.tbuffer[cli.tsq] = connectMessage
+	cli.tbuffer[cli.tsq] = cli.rto.track(connectMessage, time.Now())
 	cli.tsq++
-	WriteMessage(conn, nil, connectMessage)
+	WriteMessage(conn, nil, connectMessage, cli.enc)
 
 	go cli.receiver()
 	go cli.handler(statusSignal)
@@ -110,6 +122,9 @@ func (c *client) Read() ([]byte, error) {
 }
 
 func (c *client) Write(payload []byte) error {
+	if len(payload) > maxPayloadSize {
+		return errPayloadSize
+	}
 	message := NewData(c.id, -1, len(payload), payload)
 	c.tmsg <- message
 
@@ -135,14 +150,18 @@ func (c *client) Close() error {
 
 func (c *client) receiver() {
 	for {
-		m, _, err := ReadMessage(c.udpConn)
+		m, encoding, addr, err := ReadMessage(c.udpConn)
 		if err != nil {
 			if c.id > 0 {
 				c.status.set(connectionClosed)
 				return
 			}
 		} else {
-			c.incoming <- m
+			c.incoming <- &addressableMessage{
+				*m,
+				addr,
+				encoding,
+			}
 		}
 	}
 }
@@ -151,7 +170,7 @@ func (c *client) handler(statusSignal chan int) {
 	var epochCount int
 
 	for {
-		if c.status.get() == startClosing && len(c.tbuffer) == 0 && len(c.rbuffer) == 0 && len(c.tmsg) == 0 {
+		if c.status.get() == startClosing && len(c.tbuffer) == 0 && len(c.rbuffer) == 0 && len(c.tmsg) == 0 && len(c.fragments) == 0 {
 			c.status.set(handlerClosed)
 			return
 		}
@@ -164,27 +183,33 @@ func (c *client) handler(statusSignal chan int) {
 		}
 
 		select {
-		case m := <-c.incoming:
+		case am := <-c.incoming:
+			m := &am.Message
 			epochCount = 0
 
 			switch m.Type {
 			case MsgData:
-				if len(m.Payload) < m.Size {
+				// drop truncated and corrupted messages, they are sent again
+				if !m.valid(am.encoding) {
 					continue
 				}
-				// save data into buffer
-				if _, ok := c.rbuffer[m.SeqNum]; !ok {
-					c.rbuffer[m.SeqNum] = m.Payload
+				// save data into buffer, unless it is a duplicate of data
+				// that was already read
+				if _, ok := c.rbuffer[m.SeqNum]; !ok && m.SeqNum >= c.rsq {
+					m.Payload = m.Payload[:m.Size]
+					c.rbuffer[m.SeqNum] = m
 				}
 
 				if m.SeqNum == c.rsq {
 					i := c.rsq
 					for {
-						data, ok := c.rbuffer[i]
+						fm, ok := c.rbuffer[i]
 						if !ok {
 							break
 						}
-						c.rmsg <- data
+						if data, ok := c.partial.add(fm); ok {
+							c.rmsg <- data
+						}
 						c.rsq++
 						delete(c.rbuffer, i)
 						i++
@@ -193,14 +218,16 @@ func (c *client) handler(statusSignal chan int) {
 
 				// send ack
 				response := NewAck(c.id, m.SeqNum)
-				go WriteMessage(c.udpConn, nil, response)
+				go WriteMessage(c.udpConn, nil, response, c.enc)
 
 			case MsgAck:
 				if v, ok := c.tbuffer[m.SeqNum]; ok == true {
 					if v.Type == MsgConnect && c.id < 0 {
 						c.id = m.ConnID
+						c.enc = am.encoding
 						close(statusSignal)
 					}
+					c.rto.acked(v, time.Now())
 					delete(c.tbuffer, m.SeqNum)
 				}
 			}
@@ -214,29 +241,48 @@ func (c *client) handler(statusSignal chan int) {
 				return
 			}
 			if c.id < 0 {
+				// alternate the encodings until the server answers one of them
+				if c.enc == EncodingBinary {
+					c.enc = EncodingJSON
+				} else {
+					c.enc = EncodingBinary
+				}
 				m := NewConnect()
-				go WriteMessage(c.udpConn, nil, m)
+				go WriteMessage(c.udpConn, nil, m, c.enc)
 			} else {
 				if c.rsq == 1 && len(c.rbuffer) == 0 {
 					m := NewAck(c.id, 0)
-					go WriteMessage(c.udpConn, nil, m)
+					go WriteMessage(c.udpConn, nil, m, c.enc)
 				}
 			}
-			for _, m := range c.tbuffer {
-				go WriteMessage(c.udpConn, nil, m)
-			}
 		default:
 			time.Sleep(time.Nanosecond)
 
+			// resend the unacknowledged messages whose timeout expired
+			now := time.Now()
+			for _, p := range c.tbuffer {
+				if c.rto.expired(p, now) {
+					go WriteMessage(c.udpConn, nil, p.Message, c.enc)
+				}
+			}
+
 			if c.tsq-minUnAcked < c.windows {
-				select {
-				case m := <-c.tmsg:
-					m.SeqNum = c.tsq
+				if len(c.fragments) == 0 {
+					select {
+					case m := <-c.tmsg:
+						c.fragments = fragment(m.Payload)
+					default:
+					}
+				}
+				if len(c.fragments) > 0 {
+					// each fragment is a data message in the window, whose
+					// checksum covers the sequence number
+					m := newFragment(c.id, c.tsq, c.fragments[0], len(c.fragments) > 1)
+					c.fragments = c.fragments[1:]
 
-					c.tbuffer[c.tsq] = m
+					c.tbuffer[c.tsq] = c.rto.track(m, now)
 					c.tsq++
-					go WriteMessage(c.udpConn, nil, m)
-				default:
+					go WriteMessage(c.udpConn, nil, m, c.enc)
 				}
 			}
 		}
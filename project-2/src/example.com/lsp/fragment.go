@@ -0,0 +1,72 @@
+package lsp
+
+import "errors"
+
+// maxFragmentSize is the largest payload of a data message. Larger payloads are
+// split into fragments sent as consecutive data messages, so every message fits
+// in maxPacketSize bytes with either encoding.
+const maxFragmentSize = 1024
+
+// maxPayloadSize is the largest payload that is written and reassembled
+const maxPayloadSize = 1 << 20
+
+// errPayloadSize is returned by Write for payloads larger than maxPayloadSize
+var errPayloadSize = errors.New("[lsp] payload is too large")
+
+// newFragment returns a new data message with a fragment of a payload, more
+// tells whether other fragments follow it.
+func newFragment(connID, seqNum int, payload []byte, more bool) *Message {
+	return &Message{
+		Type:     MsgData,
+		ConnID:   connID,
+		SeqNum:   seqNum,
+		Size:     len(payload),
+		Payload:  payload,
+		More:     more,
+		Checksum: checksum(connID, seqNum, len(payload), more, payload),
+	}
+}
+
+// fragment splits a payload into the payloads of its data messages, an empty
+// payload has one empty fragment
+func fragment(payload []byte) [][]byte {
+	fragments := make([][]byte, 0, len(payload)/maxFragmentSize+1)
+	for len(payload) > maxFragmentSize {
+		fragments = append(fragments, payload[:maxFragmentSize])
+		payload = payload[maxFragmentSize:]
+	}
+	return append(fragments, payload)
+}
+
+// reassembler joins the fragments of the payloads received in order
+type reassembler struct {
+	partial   []byte // fragments of the payload being received
+	oversized bool   // the payload being received is larger than maxPayloadSize
+}
+
+// add adds the next received data message and returns the payload and true
+// once its last fragment is added. The fragments of a payload larger than
+// maxPayloadSize are discarded.
+func (r *reassembler) add(m *Message) ([]byte, bool) {
+	if r.partial == nil && !r.oversized {
+		if !m.More {
+			return m.Payload, true
+		}
+		r.partial = make([]byte, 0, 2*maxFragmentSize)
+	}
+	if !r.oversized && len(r.partial)+len(m.Payload) > maxPayloadSize {
+		r.partial = nil
+		r.oversized = true
+	}
+	if r.oversized {
+		r.oversized = m.More
+		return nil, false
+	}
+	r.partial = append(r.partial, m.Payload...)
+	if m.More {
+		return nil, false
+	}
+	payload := r.partial
+	r.partial = nil
+	return payload, true
+}
@@ -0,0 +1,86 @@
+package lsp
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"../lspnet"
+)
+
+func TestFragment(t *testing.T) {
+	for _, size := range []int{0, 1, maxFragmentSize, maxFragmentSize + 1, 5*maxFragmentSize - 1} {
+		payload := make([]byte, size)
+		rand.Read(payload)
+
+		fragments := fragment(payload)
+		if n := (size + maxFragmentSize - 1) / maxFragmentSize; len(fragments) != n && !(size == 0 && len(fragments) == 1) {
+			t.Errorf("fragment split %d bytes into %d fragments, expected %d", size, len(fragments), n)
+		}
+
+		var r reassembler
+		for i, f := range fragments {
+			if len(f) > maxFragmentSize {
+				t.Fatalf("fragment %d of %d bytes has %d bytes", i, size, len(f))
+			}
+			last := i == len(fragments)-1
+			data, ok := r.add(newFragment(73, i+1, f, !last))
+			if ok != last {
+				t.Fatalf("add returned %v for fragment %d of %d", ok, i, len(fragments))
+			}
+			if last && !bytes.Equal(data, payload) {
+				t.Errorf("reassembled %d bytes, expected %d", len(data), size)
+			}
+		}
+	}
+}
+
+func TestReassembleOversized(t *testing.T) {
+	var r reassembler
+	f := make([]byte, maxFragmentSize)
+	n := maxPayloadSize/maxFragmentSize + 2
+	for i := 0; i < n; i++ {
+		if _, ok := r.add(newFragment(73, i+1, f, i < n-1)); ok {
+			t.Fatalf("add returned a payload of more than %d bytes", maxPayloadSize)
+		}
+	}
+	if len(r.partial) != 0 {
+		t.Errorf("reassembler holds %d bytes of a discarded payload", len(r.partial))
+	}
+
+	// the next payload is reassembled
+	r.add(newFragment(73, n+1, f, true))
+	if data, ok := r.add(newFragment(73, n+2, []byte{1}, false)); !ok || len(data) != maxFragmentSize+1 {
+		t.Errorf("add returned %d bytes, %v after a discarded payload", len(data), ok)
+	}
+}
+
+func TestFragmentFits(t *testing.T) {
+	m := newFragment(1<<30, 1<<30, bytes.Repeat([]byte{0xff}, maxFragmentSize), true)
+	for _, encoding := range []Encoding{EncodingJSON, EncodingBinary} {
+		packet, err := encode(m, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(packet) > maxPacketSize {
+			t.Errorf("a fragment is encoded with encoding %d in %d bytes, more than %d", encoding, len(packet), maxPacketSize)
+		}
+	}
+}
+
+// TestLargePayloads echoes payloads that span several datagrams
+func TestLargePayloads(t *testing.T) {
+	payloads := [][]byte{
+		make([]byte, maxFragmentSize+500),
+		make([]byte, 4*maxFragmentSize),
+	}
+	for _, payload := range payloads {
+		rand.Read(payload)
+	}
+
+	echo(t, makeParams(20, 50, 1), payloads)
+
+	lspnet.SetWriteDropPercent(10)
+	defer lspnet.ResetDropPercent()
+	echo(t, makeParams(20, 50, 4), payloads)
+}
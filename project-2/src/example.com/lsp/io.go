@@ -1,33 +1,57 @@
 package lsp
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 
 	net "../lspnet"
 )
 
-// ReadMessage receives message from given connection and de-serializes it.
-func ReadMessage(connection *net.UDPConn) (*Message, *net.UDPAddr, error) {
-	packet := make([]byte, 2000)
+// Encoding is the wire format of the messages sent to a peer. A client offers
+// the binary encoding in its connect messages, and falls back to JSON on
+// every other retry so it still reaches peers that only understand JSON. The
+// server answers each client in the encoding of its connect message.
+type Encoding int
+
+const (
+	EncodingJSON   Encoding = iota // understood by every peer
+	EncodingBinary                 // compact, see encodeBinary
+)
+
+// maxPacketSize is the size of the datagram buffer of ReadMessage
+const maxPacketSize = 2000
+
+// Binary messages start with binaryMagic, which never starts a JSON message,
+// and the version of the format. lspnet parses them to alter their payloads.
+const (
+	binaryMagic   = net.BinaryMagic
+	binaryVersion = net.BinaryVersion
+)
+
+// ReadMessage receives message from given connection and de-serializes it from
+// either encoding, which is returned with the message.
+func ReadMessage(connection *net.UDPConn) (*Message, Encoding, *net.UDPAddr, error) {
+	packet := make([]byte, maxPacketSize)
 
 	n, addr, err := connection.ReadFromUDP(packet)
 	if err != nil {
-		return nil, addr, err
+		return nil, EncodingJSON, addr, err
 	}
 
-	packet = packet[0:n]
-	var message Message
-	err = json.Unmarshal(packet, &message)
+	message, encoding, err := decode(packet[0:n])
 	if err != nil {
-		return nil, addr, err
+		return nil, encoding, addr, err
 	}
-	return &message, addr, nil
+	return message, encoding, addr, nil
 
 }
 
-// WriteMessage serializes given message and send it into by given connection.
-func WriteMessage(connection *net.UDPConn, addr *net.UDPAddr, message *Message) error {
-	packet, err := json.Marshal(message)
+// WriteMessage serializes given message with given encoding and send it into by given connection.
+func WriteMessage(connection *net.UDPConn, addr *net.UDPAddr, message *Message, encoding Encoding) error {
+	packet, err := encode(message, encoding)
 	if err != nil {
 		return err
 	}
@@ -43,3 +67,75 @@ func WriteMessage(connection *net.UDPConn, addr *net.UDPAddr, message *Message)
 
 	return nil
 }
+
+func encode(message *Message, encoding Encoding) ([]byte, error) {
+	if encoding == EncodingBinary {
+		return encodeBinary(message), nil
+	}
+	return json.Marshal(message)
+}
+
+func decode(packet []byte) (*Message, Encoding, error) {
+	if len(packet) > 0 && packet[0] == binaryMagic {
+		message, err := decodeBinary(packet)
+		return message, EncodingBinary, err
+	}
+
+	var message Message
+	if err := json.Unmarshal(packet, &message); err != nil {
+		return nil, EncodingJSON, err
+	}
+	return &message, EncodingJSON, nil
+}
+
+// Flags of the binary encoding
+const (
+	binaryMore = 1 << iota
+)
+
+// encodeBinary appends to the magic and version bytes the type and flags
+// bytes, the connection ID, sequence number and size as varints, the checksum
+// as an uvarint, then the raw payload.
+func encodeBinary(message *Message) []byte {
+	var flags byte
+	if message.More {
+		flags |= binaryMore
+	}
+	packet := make([]byte, 0, 4+4*binary.MaxVarintLen64+len(message.Payload))
+	packet = append(packet, binaryMagic, binaryVersion, byte(message.Type), flags)
+	packet = binary.AppendVarint(packet, int64(message.ConnID))
+	packet = binary.AppendVarint(packet, int64(message.SeqNum))
+	packet = binary.AppendVarint(packet, int64(message.Size))
+	packet = binary.AppendUvarint(packet, uint64(message.Checksum))
+	return append(packet, message.Payload...)
+}
+
+func decodeBinary(packet []byte) (*Message, error) {
+	if len(packet) < 4 {
+		return nil, errors.New("[lsp] truncated binary message")
+	}
+	if packet[1] != binaryVersion {
+		return nil, fmt.Errorf("[lsp] unsupported binary message version %d", packet[1])
+	}
+
+	message := &Message{Type: MsgType(packet[2]), More: packet[3]&binaryMore != 0}
+	packet = packet[4:]
+	for _, field := range []*int{&message.ConnID, &message.SeqNum, &message.Size} {
+		v, n := binary.Varint(packet)
+		if n <= 0 {
+			return nil, errors.New("[lsp] truncated binary message")
+		}
+		*field = int(v)
+		packet = packet[n:]
+	}
+	sum, n := binary.Uvarint(packet)
+	if n <= 0 || sum > math.MaxUint32 {
+		return nil, errors.New("[lsp] truncated binary message")
+	}
+	message.Checksum = uint32(sum)
+	packet = packet[n:]
+	if message.Type == MsgData {
+		message.Payload = packet
+	}
+	return message, nil
+}
@@ -0,0 +1,171 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"../lspnet"
+)
+
+func TestEncoding(t *testing.T) {
+	payload, _ := json.Marshal(12345)
+	messages := []*Message{
+		NewConnect(),
+		NewData(73, 42, len(payload), payload),
+		NewData(-1, 0, 0, []byte{}),
+		NewAck(1<<40, 7),
+	}
+
+	for _, encoding := range []Encoding{EncodingJSON, EncodingBinary} {
+		for _, m := range messages {
+			packet, err := encode(m, encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, e, err := decode(packet)
+			if err != nil {
+				t.Fatalf("decode %s returned %s", m, err)
+			}
+			if e != encoding {
+				t.Errorf("decode %s returned encoding %d, expected %d", m, e, encoding)
+			}
+			if decoded.Type != m.Type || decoded.ConnID != m.ConnID || decoded.SeqNum != m.SeqNum ||
+				decoded.Size != m.Size || !bytes.Equal(decoded.Payload, m.Payload) {
+				t.Errorf("decode returned %s, expected %s", decoded, m)
+			}
+		}
+	}
+
+	packet := encodeBinary(NewAck(1, 1))
+	packet[1] = binaryVersion + 1
+	if _, _, err := decode(packet); err == nil {
+		t.Error("decode accepted an unsupported version")
+	}
+	if _, _, err := decode(packet[:4]); err == nil {
+		t.Error("decode accepted a truncated message")
+	}
+}
+
+// TestEncodingNegotiation checks that the server answers a client in the
+// encoding of its connect message
+func TestEncodingNegotiation(t *testing.T) {
+	var server Server
+	var port int
+	for server == nil {
+		port = 3000 + rand.Intn(50000)
+		server, _ = NewServer(port, makeParams(5, 200, 1))
+	}
+	defer server.Close()
+
+	for _, encoding := range []Encoding{EncodingJSON, EncodingBinary} {
+		addr, err := lspnet.ResolveUDPAddr("udp", lspnet.JoinHostPort("localhost", strconv.Itoa(port)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := lspnet.DialUDP("udp", nil, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := WriteMessage(conn, nil, NewConnect(), encoding); err != nil {
+			t.Fatal(err)
+		}
+		acked := make(chan Encoding)
+		go func() {
+			m, e, _, err := ReadMessage(conn)
+			if err == nil && m.Type == MsgAck {
+				acked <- e
+			}
+		}()
+		select {
+		case e := <-acked:
+			if e != encoding {
+				t.Errorf("server acked with encoding %d, expected %d", e, encoding)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("server did not ack a connect with encoding %d", encoding)
+		}
+		conn.Close()
+	}
+}
+
+// benchmarkPayloads are the payloads of the lsp tests, which send integers
+// marshalled as JSON, and a payload close to the size of a datagram
+var benchmarkPayloads = []struct {
+	name    string
+	payload []byte
+}{
+	{"Int", []byte("1234567")},
+	{"Large", bytes.Repeat([]byte{'x'}, 1000)},
+}
+
+func benchmarkEncoding(b *testing.B, encoding Encoding) {
+	for _, bp := range benchmarkPayloads {
+		m := NewData(73, 1000, len(bp.payload), bp.payload)
+		b.Run(bp.name, func(b *testing.B) {
+			var packet []byte
+			for i := 0; i < b.N; i++ {
+				packet, _ = encode(m, encoding)
+				if _, _, err := decode(packet); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(packet)), "bytes/msg")
+		})
+	}
+}
+
+func BenchmarkEncodingJSON(b *testing.B) {
+	benchmarkEncoding(b, EncodingJSON)
+}
+
+func BenchmarkEncodingBinary(b *testing.B) {
+	benchmarkEncoding(b, EncodingBinary)
+}
+
+// benchmarkEcho measures the round trips of payloads echoed by a server to a
+// client that connects with the given encoding
+func benchmarkEcho(b *testing.B, encoding Encoding) {
+	params := makeParams(5, 2000, 1)
+	var server Server
+	var port int
+	for server == nil {
+		port = 3000 + rand.Intn(50000)
+		server, _ = NewServer(port, params)
+	}
+	defer server.Close()
+	client, err := newClient(lspnet.JoinHostPort("localhost", strconv.Itoa(port)), params, encoding)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, bp := range benchmarkPayloads {
+		b.Run(bp.name, func(b *testing.B) {
+			b.SetBytes(int64(len(bp.payload)))
+			for i := 0; i < b.N; i++ {
+				client.Write(bp.payload)
+				connID, data, err := server.Read()
+				if err != nil {
+					b.Fatal(err)
+				}
+				server.Write(connID, data)
+				if _, err := client.Read(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEchoJSON(b *testing.B) {
+	benchmarkEcho(b, EncodingJSON)
+}
+
+func BenchmarkEchoBinary(b *testing.B) {
+	benchmarkEcho(b, EncodingBinary)
+}
@@ -474,42 +474,42 @@ func (ts *windowTestSystem) runScatteredMsgsTest() {
 }
 
 func TestWindow1(t *testing.T) {
-	newWindowTestSystem(t, doMaxCapacity, 1, 10, &Params{3, 500, 5}).
+	newWindowTestSystem(t, doMaxCapacity, 1, 10, makeParams(3, 500, 5)).
 		setDescription("TestWindow1: 1 client, max capacity").
 		setMaxEpochs(5).
 		runTest()
 }
 
 func TestWindow2(t *testing.T) {
-	newWindowTestSystem(t, doMaxCapacity, 5, 25, &Params{3, 500, 10}).
+	newWindowTestSystem(t, doMaxCapacity, 5, 25, makeParams(3, 500, 10)).
 		setDescription("TestWindow2: 5 clients, max capacity").
 		setMaxEpochs(5).
 		runTest()
 }
 
 func TestWindow3(t *testing.T) {
-	newWindowTestSystem(t, doMaxCapacity, 10, 25, &Params{3, 500, 10}).
+	newWindowTestSystem(t, doMaxCapacity, 10, 25, makeParams(3, 500, 10)).
 		setDescription("TestWindow3: 10 clients, max capacity").
 		setMaxEpochs(5).
 		runTest()
 }
 
 func TestWindow4(t *testing.T) {
-	newWindowTestSystem(t, doScatteredMsgs, 1, 10, &Params{3, 1000, 20}).
+	newWindowTestSystem(t, doScatteredMsgs, 1, 10, makeParams(3, 1000, 20)).
 		setDescription("TestWindow4: 1 client, scattered msgs").
 		setMaxEpochs(5).
 		runTest()
 }
 
 func TestWindow5(t *testing.T) {
-	newWindowTestSystem(t, doScatteredMsgs, 5, 10, &Params{3, 1000, 20}).
+	newWindowTestSystem(t, doScatteredMsgs, 5, 10, makeParams(3, 1000, 20)).
 		setDescription("TestWindow5: 5 clients, scattered msgs").
 		setMaxEpochs(5).
 		runTest()
 }
 
 func TestWindow6(t *testing.T) {
-	newWindowTestSystem(t, doScatteredMsgs, 10, 10, &Params{3, 1000, 20}).
+	newWindowTestSystem(t, doScatteredMsgs, 10, 10, makeParams(3, 1000, 20)).
 		setDescription("TestWindow6: 10 clients, scattered msgs").
 		setMaxEpochs(5).
 		runTest()
@@ -442,84 +442,84 @@ func (ts *syncTestSystem) master() {
 }
 
 func TestServerFastClose1(t *testing.T) {
-	newSyncTestSystem(t, 1, 10, doServerFastClose, &Params{5, 500, 1}).
+	newSyncTestSystem(t, 1, 10, doServerFastClose, makeParams(5, 500, 1)).
 		setDescription("TestServerFastClose1: Fast close of server").
 		setMaxEpochs(12).
 		runTest()
 }
 
 func TestServerFastClose2(t *testing.T) {
-	newSyncTestSystem(t, 3, 10, doServerFastClose, &Params{5, 500, 1}).
+	newSyncTestSystem(t, 3, 10, doServerFastClose, makeParams(5, 500, 1)).
 		setDescription("TestServerFastClose2: Fast close of server").
 		setMaxEpochs(12).
 		runTest()
 }
 
 func TestServerFastClose3(t *testing.T) {
-	newSyncTestSystem(t, 5, 500, doServerFastClose, &Params{5, 2000, 1}).
+	newSyncTestSystem(t, 5, 500, doServerFastClose, makeParams(5, 2000, 1)).
 		setDescription("TestServerFastClose3: Fast close of server").
 		setMaxEpochs(20).
 		runTest()
 }
 
 func TestServerToClient1(t *testing.T) {
-	newSyncTestSystem(t, 1, 10, doServerToClient, &Params{5, 500, 1}).
+	newSyncTestSystem(t, 1, 10, doServerToClient, makeParams(5, 500, 1)).
 		setDescription("TestServerToClient1: Stream from server to client").
 		setMaxEpochs(12).
 		runTest()
 }
 
 func TestServerToClient2(t *testing.T) {
-	newSyncTestSystem(t, 3, 10, doServerToClient, &Params{5, 500, 1}).
+	newSyncTestSystem(t, 3, 10, doServerToClient, makeParams(5, 500, 1)).
 		setDescription("TestServerToClient2: Stream from server to client").
 		setMaxEpochs(12).
 		runTest()
 }
 
 func TestServerToClient3(t *testing.T) {
-	newSyncTestSystem(t, 5, 500, doServerToClient, &Params{5, 2000, 1}).
+	newSyncTestSystem(t, 5, 500, doServerToClient, makeParams(5, 2000, 1)).
 		setDescription("TestServerToClient3: Stream from server to client").
 		setMaxEpochs(20).
 		runTest()
 }
 
 func TestClientToServer1(t *testing.T) {
-	newSyncTestSystem(t, 1, 10, doClientToServer, &Params{5, 500, 1}).
+	newSyncTestSystem(t, 1, 10, doClientToServer, makeParams(5, 500, 1)).
 		setDescription("TestClientToServer1: Stream from client to server").
 		setMaxEpochs(12).
 		runTest()
 }
 
 func TestClientToServer2(t *testing.T) {
-	newSyncTestSystem(t, 3, 10, doClientToServer, &Params{5, 500, 1}).
+	newSyncTestSystem(t, 3, 10, doClientToServer, makeParams(5, 500, 1)).
 		setDescription("TestClientToServer2: Stream from client to server").
 		setMaxEpochs(12).
 		runTest()
 }
 
 func TestClientToServer3(t *testing.T) {
-	newSyncTestSystem(t, 5, 500, doClientToServer, &Params{5, 2000, 1}).
+	newSyncTestSystem(t, 5, 500, doClientToServer, makeParams(5, 2000, 1)).
 		setDescription("TestClientToServer3: Stream from client to server").
 		setMaxEpochs(20).
 		runTest()
 }
 
 func TestRoundTrip1(t *testing.T) {
-	newSyncTestSystem(t, 1, 10, doRoundTrip, &Params{5, 500, 1}).
+	newSyncTestSystem(t, 1, 10, doRoundTrip, makeParams(5, 500, 1)).
 		setDescription("TestRoundTrip1: Buffered msgs in client and server").
 		setMaxEpochs(12).
 		runTest()
 }
 
 func TestRoundTrip2(t *testing.T) {
-	newSyncTestSystem(t, 3, 10, doRoundTrip, &Params{5, 500, 1}).
+	newSyncTestSystem(t, 3, 10, doRoundTrip, makeParams(5, 500, 1)).
 		setDescription("TestRoundTrip2: Buffered msgs in client and server").
 		setMaxEpochs(12).
 		runTest()
 }
 
 func TestRoundTrip3(t *testing.T) {
-	newSyncTestSystem(t, 5, 500, doRoundTrip, &Params{5, 2000, 1}).
+	newSyncTestSystem(t, 5, 500, doRoundTrip, makeParams(5, 2000, 1)).
 		setDescription("TestRoundTrip3: Buffered msgs in client and server").
 		setMaxEpochs(20).
 		runTest()
@@ -15,11 +15,13 @@ const (
 
 // Message represents a message used by the LSP protocol.
 type Message struct {
-	Type    MsgType // One of the message types listed above.
-	ConnID  int     // Unique client-server connection ID.
-	SeqNum  int     // Message sequence number.
-	Size    int     // Size of the payload.
-	Payload []byte  // Data message payload.
+	Type     MsgType // One of the message types listed above.
+	ConnID   int     // Unique client-server connection ID.
+	SeqNum   int     // Message sequence number.
+	Size     int     // Size of the payload.
+	Payload  []byte  // Data message payload.
+	More     bool    // More fragments of the payload follow, see fragment.
+	Checksum uint32  // Checksum of a data message, see checksum.
 }
 
 // NewConnect returns a new connect message.
@@ -31,11 +33,12 @@ func NewConnect() *Message {
 // sequence number, and payload.
 func NewData(connID, seqNum, size int, payload []byte) *Message {
 	return &Message{
-		Type:    MsgData,
-		ConnID:  connID,
-		SeqNum:  seqNum,
-		Size:    size,
-		Payload: payload,
+		Type:     MsgData,
+		ConnID:   connID,
+		SeqNum:   seqNum,
+		Size:     size,
+		Payload:  payload,
+		Checksum: checksum(connID, seqNum, size, false, payload),
 	}
 }
 
@@ -0,0 +1,182 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"../lspnet"
+)
+
+func TestChecksum(t *testing.T) {
+	m := NewData(73, 4, 5, []byte("hello"))
+	if !m.valid(EncodingBinary) {
+		t.Fatalf("valid rejected %s", m)
+	}
+
+	// the payload may be longer than its size
+	m.Payload = append(m.Payload, '!')
+	if !m.valid(EncodingBinary) {
+		t.Fatalf("valid rejected %s with a longer payload", m)
+	}
+
+	// JSON peers that predate checksums send none
+	m.Checksum = 0
+	if !m.valid(EncodingJSON) {
+		t.Fatalf("valid rejected %s without a checksum in JSON", m)
+	}
+	if m.valid(EncodingBinary) {
+		t.Fatalf("valid accepted %s without a checksum in binary", m)
+	}
+
+	corrupted := []func(m *Message){
+		func(m *Message) { m.Payload[0] ^= 1 },
+		func(m *Message) { m.Payload = m.Payload[:4] },
+		func(m *Message) { m.SeqNum++ },
+		func(m *Message) { m.ConnID++ },
+		func(m *Message) { m.Size-- },
+		func(m *Message) { m.Checksum++ },
+	}
+	for i, corrupt := range corrupted {
+		m := NewData(73, 4, 5, []byte("hello"))
+		corrupt(m)
+		if m.valid(EncodingBinary) {
+			t.Errorf("valid accepted corrupted message %d: %s", i, m)
+		}
+	}
+}
+
+// TestLegacyData sends data without a checksum over the JSON fallback, as a
+// peer that predates checksums does
+func TestLegacyData(t *testing.T) {
+	var server Server
+	var port int
+	for server == nil {
+		port = 3000 + rand.Intn(50000)
+		server, _ = NewServer(port, makeParams(5, 200, 1))
+	}
+	defer server.Close()
+
+	addr, err := lspnet.ResolveUDPAddr("udp", lspnet.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := lspnet.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := WriteMessage(conn, nil, NewConnect(), EncodingJSON); err != nil {
+		t.Fatal(err)
+	}
+	acked := make(chan *Message, 1)
+	go func() {
+		if m, _, _, err := ReadMessage(conn); err == nil {
+			acked <- m
+		}
+	}()
+	var ack *Message
+	select {
+	case ack = <-acked:
+	case <-time.After(time.Second):
+		t.Fatal("server did not ack a connect")
+	}
+
+	payload := []byte("legacy")
+	packet, _ := json.Marshal(struct {
+		Type    MsgType
+		ConnID  int
+		SeqNum  int
+		Size    int
+		Payload []byte
+	}{MsgData, ack.ConnID, 1, len(payload), payload})
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan []byte, 1)
+	go func() {
+		if _, data, err := server.Read(); err == nil {
+			read <- data
+		}
+	}()
+	select {
+	case data := <-read:
+		if !bytes.Equal(data, payload) {
+			t.Fatalf("server read %q, expected %q", data, payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server dropped data without a checksum")
+	}
+}
+
+// TestCorruption echoes messages with some flipped bits, which must be dropped
+// and sent again
+func TestCorruption(t *testing.T) {
+	lspnet.SetMsgCorruptionPercent(20)
+	defer lspnet.SetMsgCorruptionPercent(0)
+
+	payloads := make([][]byte, 20)
+	for i := range payloads {
+		payloads[i], _ = json.Marshal(i * 1000003)
+	}
+	echo(t, makeParams(20, 50, 1), payloads)
+}
+
+// echo connects a client to a new server, which echoes the given payloads
+// written by the client one at a time, and checks the payloads read on both sides
+func echo(t *testing.T, params *Params, payloads [][]byte) {
+	var server Server
+	var port int
+	for server == nil {
+		port = 3000 + rand.Intn(50000)
+		server, _ = NewServer(port, params)
+	}
+	defer server.Close()
+	client, err := NewClient(lspnet.JoinHostPort("localhost", strconv.Itoa(port)), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the echo does not call t, which must not be used once the test has timed out
+	done := make(chan error, 1)
+	go func() {
+		for i, expected := range payloads {
+			client.Write(expected)
+			connID, data, err := server.Read()
+			if err != nil {
+				done <- err
+				return
+			}
+			if !bytes.Equal(data, expected) {
+				done <- fmt.Errorf("server read %d bytes, expected the %d bytes of payload %d", len(data), len(expected), i)
+				return
+			}
+			server.Write(connID, data)
+			if data, err = client.Read(); err != nil {
+				done <- err
+				return
+			}
+			if !bytes.Equal(data, expected) {
+				done <- fmt.Errorf("client read %d bytes, expected the %d bytes of payload %d", len(data), len(expected), i)
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("echo timed out after 15 secs")
+	}
+}
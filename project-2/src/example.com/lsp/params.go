@@ -9,6 +9,8 @@ const (
 	DefaultEpochLimit  = 5
 	DefaultEpochMillis = 2000
 	DefaultWindowSize  = 1
+
+	DefaultMinRTOMillis = 10
 )
 
 // Params defines configuration parameters for an LSP client or server.
@@ -23,6 +25,14 @@ type Params struct {
 	// WindowSize is the size of the sliding window (i.e. the max number of
 	// non-acknowledged messages that can be sent at a given time).
 	WindowSize int
+
+	// MinRTOMillis and MaxRTOMillis bound the number of milliseconds to wait
+	// for the acknowledgement of a message before sending it again. This
+	// timeout is estimated from the round trip times of the connection and
+	// doubled by every retransmission of the message. Zero values stand for
+	// DefaultMinRTOMillis and EpochMillis.
+	MinRTOMillis int
+	MaxRTOMillis int
 }
 
 // NewParams returns a Params with default field values.
@@ -31,6 +41,8 @@ func NewParams() *Params {
 		EpochLimit:  DefaultEpochLimit,
 		EpochMillis: DefaultEpochMillis,
 		WindowSize:  DefaultWindowSize,
+
+		MinRTOMillis: DefaultMinRTOMillis,
 	}
 }
 
@@ -39,6 +51,6 @@ func NewParams() *Params {
 //     params := NewParams()
 //     fmt.Printf("New params: %s\n", params)
 func (p *Params) String() string {
-	return fmt.Sprintf("[EpochLimit: %d, EpochMillis: %d, WindowSize: %d]",
-		p.EpochLimit, p.EpochMillis, p.WindowSize)
+	return fmt.Sprintf("[EpochLimit: %d, EpochMillis: %d, WindowSize: %d, MinRTOMillis: %d, MaxRTOMillis: %d]",
+		p.EpochLimit, p.EpochMillis, p.WindowSize, p.MinRTOMillis, p.MaxRTOMillis)
 }
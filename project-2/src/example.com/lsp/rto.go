@@ -0,0 +1,97 @@
+package lsp
+
+import "time"
+
+// rtoEstimator estimates the retransmission timeout of a connection from the
+// round trip times of its acknowledged messages, as described by Jacobson and
+// Karels. Only the messages that were sent once are sampled (Karn's algorithm).
+type rtoEstimator struct {
+	srtt, rttvar time.Duration // smoothed round trip time and its variation
+	rto          time.Duration
+	min, max     time.Duration
+	sampled      bool
+}
+
+// pending is a data or connect message waiting for its acknowledgement
+type pending struct {
+	*Message
+	sent     time.Time // first transmission
+	deadline time.Time // next retransmission
+	retries  int
+}
+
+func newRTOEstimator(params *Params) *rtoEstimator {
+	min := time.Duration(params.MinRTOMillis) * time.Millisecond
+	if min <= 0 {
+		min = DefaultMinRTOMillis * time.Millisecond
+	}
+	max := time.Duration(params.MaxRTOMillis) * time.Millisecond
+	if max <= 0 {
+		max = time.Duration(params.EpochMillis) * time.Millisecond
+	}
+	if min > max {
+		min = max
+	}
+	// without samples the timeout is the largest one
+	return &rtoEstimator{rto: max, min: min, max: max}
+}
+
+// sample updates the timeout with the round trip time of a message
+func (e *rtoEstimator) sample(rtt time.Duration) {
+	if !e.sampled {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+		e.sampled = true
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+	e.rto = e.clamp(e.srtt + 4*e.rttvar)
+}
+
+// timeout returns the time to wait for the acknowledgement of a message that
+// was retransmitted the given number of times, which doubles it every time
+func (e *rtoEstimator) timeout(retries int) time.Duration {
+	rto := e.rto
+	for i := 0; i < retries && rto < e.max; i++ {
+		rto *= 2
+	}
+	return e.clamp(rto)
+}
+
+func (e *rtoEstimator) clamp(rto time.Duration) time.Duration {
+	if rto < e.min {
+		return e.min
+	}
+	if rto > e.max {
+		return e.max
+	}
+	return rto
+}
+
+// track returns the pending state of a message sent now
+func (e *rtoEstimator) track(m *Message, now time.Time) *pending {
+	return &pending{Message: m, sent: now, deadline: now.Add(e.timeout(0))}
+}
+
+// acked samples the round trip time of an acknowledged message
+func (e *rtoEstimator) acked(p *pending, now time.Time) {
+	if p.retries == 0 {
+		e.sample(now.Sub(p.sent))
+	}
+}
+
+// expired reports whether a message must be retransmitted now, and backs off
+// its next retransmission if so
+func (e *rtoEstimator) expired(p *pending, now time.Time) bool {
+	if now.Before(p.deadline) {
+		return false
+	}
+	p.retries++
+	p.deadline = now.Add(e.timeout(p.retries))
+	return true
+}
@@ -0,0 +1,100 @@
+package lsp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"../lspnet"
+)
+
+func TestRTOEstimator(t *testing.T) {
+	params := makeParams(5, 1000, 1)
+	params.MinRTOMillis = 20
+	e := newRTOEstimator(params)
+
+	// without samples the timeout is the largest one
+	if rto := e.timeout(0); rto != time.Second {
+		t.Fatalf("timeout returned %s without samples, expected 1s", rto)
+	}
+
+	e.sample(100 * time.Millisecond)
+	if rto := e.timeout(0); rto != 300*time.Millisecond {
+		t.Fatalf("timeout returned %s after a sample of 100ms, expected 300ms", rto)
+	}
+	for i := 0; i < 100; i++ {
+		e.sample(100 * time.Millisecond)
+	}
+	if rto := e.timeout(0); rto < 100*time.Millisecond || rto > 110*time.Millisecond {
+		t.Fatalf("timeout returned %s after stable samples of 100ms, expected about 100ms", rto)
+	}
+
+	// retransmissions double the timeout up to the largest one
+	if r0, r1, r2 := e.timeout(0), e.timeout(1), e.timeout(2); r1 != 2*r0 || r2 != 4*r0 {
+		t.Fatalf("timeout returned %s, %s and %s, expected doubling timeouts", r0, r1, r2)
+	}
+	if rto := e.timeout(10); rto != time.Second {
+		t.Fatalf("timeout returned %s after 10 retransmissions, expected 1s", rto)
+	}
+
+	for i := 0; i < 100; i++ {
+		e.sample(time.Millisecond)
+	}
+	if rto := e.timeout(0); rto != 20*time.Millisecond {
+		t.Fatalf("timeout returned %s after samples of 1ms, expected the smallest timeout 20ms", rto)
+	}
+}
+
+func TestRTOPending(t *testing.T) {
+	e := newRTOEstimator(makeParams(5, 1000, 1))
+	e.sample(100 * time.Millisecond)
+	rto := e.timeout(0)
+
+	now := time.Now()
+	p := e.track(NewConnect(), now)
+	if e.expired(p, now.Add(rto-time.Millisecond)) {
+		t.Fatal("expired before the timeout")
+	}
+	if !e.expired(p, now.Add(rto)) || p.retries != 1 {
+		t.Fatal("not expired after the timeout")
+	}
+	// the next retransmission is backed off
+	if e.expired(p, now.Add(rto+2*rto-time.Millisecond)) || !e.expired(p, now.Add(3*rto)) {
+		t.Fatal("retransmission is not backed off")
+	}
+
+	// retransmitted messages are not sampled
+	e.acked(p, now.Add(time.Hour))
+	if e.timeout(0) != rto {
+		t.Fatalf("timeout changed to %s with the acknowledgement of a retransmitted message", e.timeout(0))
+	}
+	e.acked(e.track(NewConnect(), now), now.Add(100*time.Millisecond))
+	if e.timeout(0) == rto {
+		t.Fatal("timeout did not change with the acknowledgement of a message")
+	}
+}
+
+// TestRTODefaults checks that the largest timeout of the default params
+// follows the epoch duration
+func TestRTODefaults(t *testing.T) {
+	params := NewParams()
+	params.EpochMillis = 500
+	if rto := newRTOEstimator(params).timeout(10); rto != 500*time.Millisecond {
+		t.Fatalf("timeout returned %s with epochs of 500ms, expected 500ms", rto)
+	}
+}
+
+// TestRTOLossy echoes messages on a lossy link whose epochs are too long to
+// resend them in time
+func TestRTOLossy(t *testing.T) {
+	lspnet.SetWriteDropPercent(20)
+	defer lspnet.ResetDropPercent()
+
+	params := makeParams(5, 5000, 1)
+	params.MaxRTOMillis = 50
+	payloads := make([][]byte, 10)
+	for i := range payloads {
+		payloads[i], _ = json.Marshal(i)
+	}
+	echo(t, params, payloads)
+}
@@ -31,14 +31,19 @@ type clientInfo struct {
 	id      int
 	addr    *net.UDPAddr
 	udpConn *net.UDPConn
+	enc     Encoding // the encoding of its connect message
 
 	incoming chan *Message
 
-	tbuffer map[int]*Message
+	tbuffer map[int]*pending
+	rto     *rtoEstimator
 	tmsg    chan *Message
 	tsq     int
 
-	rbuffer map[int][]byte
+	fragments [][]byte // fragments of the payload being sent
+
+	rbuffer map[int]*Message
+	partial reassembler
 	rsq     int
 
 	timer   <-chan time.Time
@@ -60,7 +65,8 @@ type clientError struct {
 
 type addressableMessage struct {
 	Message
-	addr *net.UDPAddr
+	addr     *net.UDPAddr
+	encoding Encoding
 }
 
 // NewServer creates, initiates, and returns a new server. This function should
@@ -113,6 +119,9 @@ func (s *server) Read() (int, []byte, error) {
 }
 
 func (s *server) Write(connID int, payload []byte) error {
+	if len(payload) > maxPayloadSize {
+		return errPayloadSize
+	}
 	message := NewData(connID, -1, len(payload), payload)
 	s.outgoing <- message
 
@@ -143,7 +152,7 @@ func (s *server) Close() error {
 
 func (s *server) receiver() {
 	for {
-		m, addr, err := ReadMessage(s.udpConn)
+		m, encoding, addr, err := ReadMessage(s.udpConn)
 		if err != nil {
 			if s.status.get() == handlerClosed {
 				s.status.set(connectionClosed)
@@ -153,14 +162,23 @@ func (s *server) receiver() {
 			s.incoming <- &addressableMessage{
 				*m,
 				addr,
+				encoding,
 			}
 		}
 	}
 }
 
 func (s *server) handle(params *Params) {
+	bcls := s.bcls
 	for {
 		select {
+		case <-bcls:
+			// the closing clients return through cls, but none may be left
+			bcls = nil
+			if len(s.clients) == 0 {
+				s.status.set(handlerClosed)
+				return
+			}
 		case am := <-s.incoming:
 			m := am.Message
 			addr := am.addr
@@ -184,14 +202,16 @@ func (s *server) handle(params *Params) {
 					id:      s.lastConnID,
 					addr:    addr,
 					udpConn: s.udpConn,
+					enc:     am.encoding,
 
 					incoming: make(chan *Message, 1024),
 
-					tbuffer: make(map[int]*Message),
+					tbuffer: make(map[int]*pending),
+					rto:     newRTOEstimator(params),
 					tmsg:    make(chan *Message, 1024),
 					tsq:     1,
 
-					rbuffer: make(map[int][]byte),
+					rbuffer: make(map[int]*Message),
 					rsq:     1,
 
 					timer:   time.Tick(time.Duration(params.EpochMillis) * time.Millisecond),
@@ -206,7 +226,7 @@ func (s *server) handle(params *Params) {
 
 				// send ack
 				response := NewAck(client.id, 0)
-				go WriteMessage(s.udpConn, addr, response)
+				go WriteMessage(s.udpConn, addr, response, client.enc)
 			default:
 				if client, ok := s.clients[m.ConnID]; ok {
 					client.incoming <- &m
@@ -236,7 +256,7 @@ func (c *clientInfo) handleClient(s *server) {
 	var epochCount int
 
 	for {
-		if c.status.get() == startClosing && len(c.tbuffer) == 0 && len(c.rbuffer) == 0 && len(c.tmsg) == 0 {
+		if c.status.get() == startClosing && len(c.tbuffer) == 0 && len(c.rbuffer) == 0 && len(c.tmsg) == 0 && len(c.fragments) == 0 {
 			c.status.set(handlerClosed)
 			s.cls <- c.id
 			return
@@ -255,23 +275,28 @@ func (c *clientInfo) handleClient(s *server) {
 
 			switch m.Type {
 			case MsgData:
-				if len(m.Payload) < m.Size {
+				// drop truncated and corrupted messages, they are sent again
+				if !m.valid(c.enc) {
 					continue
 				}
 
-				// save data into buffer
-				if _, ok := c.rbuffer[m.SeqNum]; !ok {
-					c.rbuffer[m.SeqNum] = m.Payload
+				// save data into buffer, unless it is a duplicate of data
+				// that was already read
+				if _, ok := c.rbuffer[m.SeqNum]; !ok && m.SeqNum >= c.rsq {
+					m.Payload = m.Payload[:m.Size]
+					c.rbuffer[m.SeqNum] = m
 				}
 
 				if m.SeqNum == c.rsq {
 					i := c.rsq
 					for {
-						data, ok := c.rbuffer[i]
+						fm, ok := c.rbuffer[i]
 						if !ok {
 							break
 						}
-						s.rmsg <- &clientData{c.id, data}
+						if data, ok := c.partial.add(fm); ok {
+							s.rmsg <- &clientData{c.id, data}
+						}
 						c.rsq++
 						delete(c.rbuffer, i)
 						i++
@@ -280,10 +305,11 @@ func (c *clientInfo) handleClient(s *server) {
 
 				// send ack
 				response := NewAck(c.id, m.SeqNum)
-				go WriteMessage(s.udpConn, c.addr, response)
+				go WriteMessage(s.udpConn, c.addr, response, c.enc)
 
 			case MsgAck:
-				if _, ok := c.tbuffer[m.SeqNum]; ok {
+				if p, ok := c.tbuffer[m.SeqNum]; ok {
+					c.rto.acked(p, time.Now())
 					delete(c.tbuffer, m.SeqNum)
 				}
 			}
@@ -303,23 +329,36 @@ func (c *clientInfo) handleClient(s *server) {
 
 			if c.rsq == 1 && len(c.rbuffer) == 0 {
 				outMessage := NewAck(c.id, 0)
-				go WriteMessage(s.udpConn, c.addr, outMessage)
-			}
-			for _, outMessage := range c.tbuffer {
-				go WriteMessage(s.udpConn, c.addr, outMessage)
+				go WriteMessage(s.udpConn, c.addr, outMessage, c.enc)
 			}
 		default:
 			time.Sleep(time.Nanosecond)
 
+			// resend the unacknowledged messages whose timeout expired
+			now := time.Now()
+			for _, p := range c.tbuffer {
+				if c.rto.expired(p, now) {
+					go WriteMessage(s.udpConn, c.addr, p.Message, c.enc)
+				}
+			}
+
 			if c.tsq-minUnAcked < c.windows {
-				select {
-				case m := <-c.tmsg:
-					m.SeqNum = c.tsq
+				if len(c.fragments) == 0 {
+					select {
+					case m := <-c.tmsg:
+						c.fragments = fragment(m.Payload)
+					default:
+					}
+				}
+				if len(c.fragments) > 0 {
+					// each fragment is a data message in the window, whose
+					// checksum covers the sequence number
+					m := newFragment(c.id, c.tsq, c.fragments[0], len(c.fragments) > 1)
+					c.fragments = c.fragments[1:]
 
-					c.tbuffer[c.tsq] = m
+					c.tbuffer[c.tsq] = c.rto.track(m, now)
 					c.tsq++
-					go WriteMessage(s.udpConn, c.addr, m)
-				default:
+					go WriteMessage(s.udpConn, c.addr, m, c.enc)
 				}
 			}
 
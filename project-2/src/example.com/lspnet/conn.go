@@ -3,6 +3,7 @@
 package lspnet
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"log"
@@ -16,13 +17,23 @@ var enableDebugLogs uint32
 // This really shouldn't be here as it is a break of abstraction,
 // but it is a minor hack to vary the payload length.
 type TemporaryMessage struct {
-	Type    int
-	ConnID  int
-	SeqNum  int
-	Size    int
-	Payload []byte
+	Type     int
+	ConnID   int
+	SeqNum   int
+	Size     int
+	Payload  []byte
+	More     bool
+	Checksum uint32
 }
 
+// The binary encoding of the lsp package, which starts with BinaryMagic and
+// BinaryVersion, is a break of abstraction too. BinaryMagic never starts a
+// JSON message.
+const (
+	BinaryMagic   = 0xf5
+	BinaryVersion = 3
+)
+
 // EnableDebugLogs has log messages directed to standard output if enable is true.
 func EnableDebugLogs(enable bool) {
 	if enable {
@@ -108,38 +119,28 @@ func (c *UDPConn) write(b []byte, addr *UDPAddr) (int, error) {
 	// This uses semantic packet data (i.e. assumes it's a "Message").
 	// This is not optimal and breaks an abstraction, but is sufficient
 	// for the task at hand.
-	var msg TemporaryMessage
-	err := json.Unmarshal(b, &msg)
-	if err != nil {
-		log.Printf("This should never be reached")
-	}
-
-	if msg.Type == 1 {
-		shorten := sometimes(int(atomic.LoadUint32(&msgShorteningPercent)))
-		lengthen := sometimes(int(atomic.LoadUint32(&msgLengtheningPercent)))
-
-		if shorten {
-			var payload int
-			err = json.Unmarshal(msg.Payload, &payload)
-			if err != nil {
-				shorterPayload, _ := json.Marshal(payload / 1000)
-				msg.Payload = shorterPayload
-			} else {
-				msg.Payload = msg.Payload[:len(msg.Payload)/2]
-			}
-		} else if lengthen {
-			var payload int
-			err = json.Unmarshal(msg.Payload, &payload)
-			if err != nil {
-				longerPayload, _ := json.Marshal(payload * 1000)
-				msg.Payload = longerPayload
-			} else {
-				msg.Payload = append(msg.Payload, 2, 3, 4)
+	if len(b) > 0 && b[0] == BinaryMagic {
+		if offset, ok := binaryPayloadOffset(b); ok {
+			payload, varied := vary(b[offset:])
+			payload, corrupted := corrupt(payload)
+			if varied || corrupted {
+				b = append(b[:offset:offset], payload...)
 			}
 		}
+	} else {
+		var msg TemporaryMessage
+		err := json.Unmarshal(b, &msg)
+		if err != nil {
+			log.Printf("This should never be reached")
+		}
 
-		if shorten || lengthen {
-			b, _ = json.Marshal(msg)
+		if msg.Type == 1 {
+			var varied, corrupted bool
+			msg.Payload, varied = vary(msg.Payload)
+			msg.Payload, corrupted = corrupt(msg.Payload)
+			if varied || corrupted {
+				b, _ = json.Marshal(msg)
+			}
 		}
 	}
 
@@ -153,6 +154,66 @@ func (c *UDPConn) write(b []byte, addr *UDPAddr) (int, error) {
 	return c.nconn.WriteToUDP(b, addr.toNet())
 }
 
+// vary shortens or lengthens the payload of a data message as configured and
+// reports whether it did
+func vary(payload []byte) ([]byte, bool) {
+	shorten := sometimes(int(atomic.LoadUint32(&msgShorteningPercent)))
+	lengthen := sometimes(int(atomic.LoadUint32(&msgLengtheningPercent)))
+
+	if shorten {
+		var v int
+		err := json.Unmarshal(payload, &v)
+		if err != nil {
+			shorterPayload, _ := json.Marshal(v / 1000)
+			return shorterPayload, true
+		}
+		return payload[:len(payload)/2], true
+	} else if lengthen {
+		var v int
+		err := json.Unmarshal(payload, &v)
+		if err != nil {
+			longerPayload, _ := json.Marshal(v * 1000)
+			return longerPayload, true
+		}
+		return append(payload[:len(payload):len(payload)], 2, 3, 4), true
+	}
+	return payload, false
+}
+
+// corrupt flips a random bit of a copy of the payload of a data message as
+// configured and reports whether it did
+func corrupt(payload []byte) ([]byte, bool) {
+	if len(payload) == 0 || !sometimes(int(atomic.LoadUint32(&msgCorruptionPercent))) {
+		return payload, false
+	}
+	corrupted := append([]byte(nil), payload...)
+	i := rand.Intn(len(corrupted) * 8)
+	corrupted[i/8] ^= 1 << uint(i%8)
+	if isLoggingEnabled() {
+		log.Printf("CORRUPTING bit %d of written payload of length %d\n", i, len(payload))
+	}
+	return corrupted, true
+}
+
+// binaryPayloadOffset returns the offset of the payload of a message in the
+// binary encoding of the lsp package and reports whether it is a data message.
+// The payload follows the magic, the version, the type, the flags, three
+// varints and the uvarint checksum.
+func binaryPayloadOffset(b []byte) (int, bool) {
+	if len(b) < 4 || b[1] != BinaryVersion || b[2] != 1 {
+		return 0, false
+	}
+	offset := 4
+	for i := 0; i < 4; i++ {
+		_, n := binary.Varint(b[offset:])
+		if n <= 0 {
+			return 0, false
+		}
+		offset += n
+	}
+	return offset, true
+}
+
 // Close closes the connection.
 func (c *UDPConn) Close() error {
 	mapMutex.Lock()
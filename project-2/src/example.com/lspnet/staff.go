@@ -12,6 +12,7 @@ var (
 	serverWriteDropPercent uint32
 	msgShorteningPercent   uint32
 	msgLengtheningPercent  uint32
+	msgCorruptionPercent   uint32
 )
 
 // SetReadDropPercent sets the read drop percent for both clients and servers.
@@ -40,6 +41,14 @@ func SetMsgLengtheningPercent(p int) {
 	}
 }
 
+// SetMsgCorruptionPercent sets the percent of data messages written by clients
+// and servers that have a random bit of their payload flipped.
+func SetMsgCorruptionPercent(p int) {
+	if 0 <= p && p <= 100 {
+		atomic.StoreUint32(&msgCorruptionPercent, uint32(p))
+	}
+}
+
 // SetClientReadDropPercent sets the read drop percent for clients.
 func SetClientReadDropPercent(p int) {
 	if 0 <= p && p <= 100 {
@@ -19,6 +19,8 @@ var (
 	epochLimit  = flag.Int("elim", lsp.DefaultEpochLimit, "epoch limit")
 	epochMillis = flag.Int("ems", lsp.DefaultEpochMillis, "epoch duration (ms)")
 	windowSize  = flag.Int("wsize", lsp.DefaultWindowSize, "window size")
+	minRTO      = flag.Int("minrto", lsp.DefaultMinRTOMillis, "minimum retransmission timeout (ms)")
+	maxRTO      = flag.Int("maxrto", 0, "maximum retransmission timeout (ms), the epoch duration if 0")
 	showLogs    = flag.Bool("v", false, "show srunner logs")
 )
 
@@ -40,6 +42,9 @@ func main() {
 		EpochLimit:  *epochLimit,
 		EpochMillis: *epochMillis,
 		WindowSize:  *windowSize,
+
+		MinRTOMillis: *minRTO,
+		MaxRTOMillis: *maxRTO,
 	}
 	fmt.Printf("Starting server on port %d...\n", *port)
 	srv, err := lsp.NewServer(*port, params)